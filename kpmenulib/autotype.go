@@ -0,0 +1,396 @@
+package kpmenulib
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/shlex"
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// AutotypeDefaultSequence is the sequence used by KeePass when neither the entry nor its groups define one
+const AutotypeDefaultSequence = "{USERNAME}{TAB}{PASSWORD}{ENTER}"
+
+// AutotypeAction is a single action of an autotype sequence
+type AutotypeAction struct {
+	Text      string        // Text to type
+	Key       string        // Key to press, a KeePass key name (e.g. TAB) or a single character
+	Modifiers []string      // Modifiers held while pressing Key (shift, ctrl, alt)
+	Delay     time.Duration // Pause before the next action
+}
+
+// autotypeKey maps a KeePass key name to its X keysym and Linux input event code
+type autotypeKey struct {
+	keysym string
+	code   int
+}
+
+var autotypeKeys = map[string]autotypeKey{
+	"TAB":       {"Tab", 15},
+	"ENTER":     {"Return", 28},
+	"SPACE":     {"space", 57},
+	"BACKSPACE": {"BackSpace", 14},
+	"BS":        {"BackSpace", 14},
+	"BKSP":      {"BackSpace", 14},
+	"DEL":       {"Delete", 111},
+	"DELETE":    {"Delete", 111},
+	"ESC":       {"Escape", 1},
+	"HOME":      {"Home", 102},
+	"END":       {"End", 107},
+	"INS":       {"Insert", 110},
+	"INSERT":    {"Insert", 110},
+	"LEFT":      {"Left", 105},
+	"RIGHT":     {"Right", 106},
+	"UP":        {"Up", 103},
+	"DOWN":      {"Down", 108},
+	"PGUP":      {"Prior", 104},
+	"PGDN":      {"Next", 109},
+	"CAPSLOCK":  {"Caps_Lock", 58},
+	"WIN":       {"Super_L", 125},
+	"LWIN":      {"Super_L", 125},
+	"RWIN":      {"Super_R", 126},
+	"APPS":      {"Menu", 127},
+	"F1":        {"F1", 59},
+	"F2":        {"F2", 60},
+	"F3":        {"F3", 61},
+	"F4":        {"F4", 62},
+	"F5":        {"F5", 63},
+	"F6":        {"F6", 64},
+	"F7":        {"F7", 65},
+	"F8":        {"F8", 66},
+	"F9":        {"F9", 67},
+	"F10":       {"F10", 68},
+	"F11":       {"F11", 87},
+	"F12":       {"F12", 88},
+}
+
+// autotypeCharCodes are the Linux input event codes of characters that can be pressed with a modifier
+var autotypeCharCodes = map[rune]int{
+	'1': 2, '2': 3, '3': 4, '4': 5, '5': 6, '6': 7, '7': 8, '8': 9, '9': 10, '0': 11,
+	'q': 16, 'w': 17, 'e': 18, 'r': 19, 't': 20, 'y': 21, 'u': 22, 'i': 23, 'o': 24, 'p': 25,
+	'a': 30, 's': 31, 'd': 32, 'f': 33, 'g': 34, 'h': 35, 'j': 36, 'k': 37, 'l': 38,
+	'z': 44, 'x': 45, 'c': 46, 'v': 47, 'b': 48, 'n': 49, 'm': 50,
+}
+
+var autotypeModifiers = map[rune]string{
+	'+': "shift",
+	'^': "ctrl",
+	'%': "alt",
+}
+
+var autotypeModifierCodes = map[string]int{
+	"shift": 42,
+	"ctrl":  29,
+	"alt":   56,
+}
+
+// ParseAutotypeSequence parses a KeePass autotype sequence, resolving field placeholders with the entry values.
+//
+// Supported syntax:
+//
+//	{USERNAME} {PASSWORD} {TITLE} {URL} {NOTES} {S:Field} {TOTP}   field placeholders
+//	{TAB} {ENTER} {F5 2} ...                                        key names, with optional repetition
+//	{DELAY 500}                                                     pause in milliseconds
+//	{DELAY=100}                                                     pause between every following action
+//	+ ^ % ~                                                         shift, ctrl, alt modifiers and enter
+//	{+} {^} {%} {~} {(} {)} {{} {}}                                 literal characters
+func ParseAutotypeSequence(sequence string, entry gokeepasslib.Entry) ([]AutotypeAction, error) {
+	var actions []AutotypeAction
+	var text strings.Builder
+	var modifiers []string
+	var defaultDelay time.Duration
+
+	add := func(action AutotypeAction) {
+		if defaultDelay > 0 && action.Delay == 0 {
+			action.Delay = defaultDelay
+		}
+		actions = append(actions, action)
+	}
+	flushText := func() {
+		if text.Len() > 0 {
+			add(AutotypeAction{Text: text.String()})
+			text.Reset()
+		}
+	}
+	// addText types the text, or presses its first character if modifiers are pending
+	addText := func(s string) error {
+		if len(modifiers) == 0 {
+			text.WriteString(s)
+			return nil
+		}
+		flushText()
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError {
+			return errors.New("modifier without a key")
+		}
+		add(AutotypeAction{Key: strings.ToLower(string(r)), Modifiers: modifiers})
+		modifiers = nil
+		text.WriteString(s[size:])
+		return nil
+	}
+	addKey := func(key string, times int) {
+		flushText()
+		for i := 0; i < times; i++ {
+			add(AutotypeAction{Key: key, Modifiers: modifiers})
+		}
+		modifiers = nil
+	}
+
+	for i := 0; i < len(sequence); {
+		r, size := utf8.DecodeRuneInString(sequence[i:])
+		switch {
+		case autotypeModifiers[r] != "":
+			modifiers = append(modifiers, autotypeModifiers[r])
+			i += size
+			continue
+		case r == '~':
+			addKey("ENTER", 1)
+			i += size
+			continue
+		case r == '(' || r == ')':
+			return nil, fmt.Errorf("modifier groups are not supported: %s", sequence)
+		case r != '{':
+			if err := addText(string(r)); err != nil {
+				return nil, err
+			}
+			i += size
+			continue
+		}
+
+		// Placeholder, the first character after { is always part of it (e.g. {}})
+		end := -1
+		if i+2 <= len(sequence) {
+			end = strings.IndexRune(sequence[i+2:], '}')
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in sequence: %s", sequence)
+		}
+		placeholder := sequence[i+1 : i+2+end]
+		i += end + 3
+
+		// Literal characters
+		if utf8.RuneCountInString(placeholder) == 1 && strings.ContainsAny(placeholder, "+^%~(){}") {
+			if err := addText(placeholder); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		upper := strings.ToUpper(placeholder)
+		switch {
+		case strings.HasPrefix(upper, "DELAY="):
+			ms, err := strconv.Atoi(strings.TrimSpace(placeholder[6:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay %s: %v", placeholder, err)
+			}
+			defaultDelay = time.Duration(ms) * time.Millisecond
+			continue
+		case strings.HasPrefix(upper, "DELAY "):
+			ms, err := strconv.Atoi(strings.TrimSpace(placeholder[6:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay %s: %v", placeholder, err)
+			}
+			flushText()
+			add(AutotypeAction{Delay: time.Duration(ms) * time.Millisecond})
+			continue
+		}
+
+		// Field placeholders
+		value, ok, err := autotypeFieldValue(placeholder, entry)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if err := addText(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Key name with optional repetition
+		name, times := upper, 1
+		if parts := strings.Fields(upper); len(parts) == 2 {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid repetition %s: %v", placeholder, err)
+			}
+			name, times = parts[0], n
+		}
+		if _, ok := autotypeKeys[name]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s}", placeholder)
+		}
+		addKey(name, times)
+	}
+
+	if len(modifiers) > 0 {
+		return nil, errors.New("modifier without a key at the end of the sequence")
+	}
+	flushText()
+	return actions, nil
+}
+
+// autotypeFieldValue resolves a field placeholder, ok is false if the placeholder isn't a field
+func autotypeFieldValue(placeholder string, entry gokeepasslib.Entry) (value string, ok bool, err error) {
+	upper := strings.ToUpper(placeholder)
+	if strings.HasPrefix(upper, "S:") {
+		return entry.GetContent(placeholder[2:]), true, nil
+	}
+
+	switch upper {
+	case "USERNAME":
+		return entry.GetContent("UserName"), true, nil
+	case "PASSWORD":
+		return entry.GetPassword(), true, nil
+	case "TITLE":
+		return entry.GetTitle(), true, nil
+	case "URL":
+		return entry.GetContent("URL"), true, nil
+	case "NOTES":
+		return entry.GetContent("Notes"), true, nil
+	case "TOTP":
+		value, err = CreateOTP(entry, time.Now().Unix())
+		if err != nil {
+			return "", true, fmt.Errorf("failed to create otp: %v", err)
+		}
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// Autotype types the actions into the focused window with the configured autotype tool
+func Autotype(menu *Menu, actions []AutotypeAction) error {
+	for _, action := range actions {
+		var cmd *exec.Cmd
+		var err error
+		if action.Text != "" {
+			cmd, err = autotypeTextCommand(menu)
+			if cmd != nil {
+				cmd.Stdin = strings.NewReader(action.Text)
+			}
+		} else if action.Key != "" {
+			cmd, err = autotypeKeyCommand(menu, action.Key, action.Modifiers)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Run exec
+		if cmd != nil {
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("failed to execute '%s': %v", menu.Configuration.General.AutotypeTool, err)
+			}
+		}
+		if action.Delay > 0 {
+			time.Sleep(action.Delay)
+		}
+	}
+	return nil
+}
+
+// autotypeTextCommand returns the command that types the text given as stdin
+func autotypeTextCommand(menu *Menu) (*exec.Cmd, error) {
+	switch menu.Configuration.General.AutotypeTool {
+	case AutotypeToolXdotool:
+		return exec.Command("xdotool", "type", "--clearmodifiers", "--file", "-"), nil
+	case AutotypeToolWtype:
+		return exec.Command("wtype", "-"), nil
+	case AutotypeToolYdotool:
+		return exec.Command("ydotool", "type", "--file", "-"), nil
+	case AutotypeToolCustom:
+		return customAutotypeCommand(menu.Configuration.Executable.CustomAutotypeText, "text")
+	}
+	return nil, fmt.Errorf("invalid autotype tool %s", menu.Configuration.General.AutotypeTool)
+}
+
+// autotypeKeyCommand returns the command that presses the key while holding the modifiers
+func autotypeKeyCommand(menu *Menu, key string, modifiers []string) (*exec.Cmd, error) {
+	keysym, code := key, 0
+	if k, ok := autotypeKeys[key]; ok {
+		keysym, code = k.keysym, k.code
+	} else {
+		r, _ := utf8.DecodeRuneInString(key)
+		code = autotypeCharCodes[r]
+	}
+
+	switch menu.Configuration.General.AutotypeTool {
+	case AutotypeToolXdotool:
+		return exec.Command("xdotool", "key", "--clearmodifiers", strings.Join(append(modifiers, keysym), "+")), nil
+	case AutotypeToolWtype:
+		var args []string
+		for _, m := range modifiers {
+			args = append(args, "-M", m)
+		}
+		args = append(args, "-k", keysym)
+		for _, m := range modifiers {
+			args = append(args, "-m", m)
+		}
+		return exec.Command("wtype", args...), nil
+	case AutotypeToolYdotool:
+		if code == 0 {
+			return nil, fmt.Errorf("key %s is not supported by ydotool", key)
+		}
+		var args []string
+		for _, m := range modifiers {
+			args = append(args, fmt.Sprintf("%d:1", autotypeModifierCodes[m]))
+		}
+		args = append(args, fmt.Sprintf("%d:1", code), fmt.Sprintf("%d:0", code))
+		for _, m := range modifiers {
+			args = append(args, fmt.Sprintf("%d:0", autotypeModifierCodes[m]))
+		}
+		return exec.Command("ydotool", append([]string{"key"}, args...)...), nil
+	case AutotypeToolCustom:
+		cmd, err := customAutotypeCommand(menu.Configuration.Executable.CustomAutotypeKey, "key")
+		if cmd != nil {
+			cmd.Args = append(cmd.Args, strings.Join(append(modifiers, keysym), "+"))
+		}
+		return cmd, err
+	}
+	return nil, fmt.Errorf("invalid autotype tool %s", menu.Configuration.General.AutotypeTool)
+}
+
+func customAutotypeCommand(executable string, name string) (*exec.Cmd, error) {
+	customCommand, err := shlex.Split(executable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse custom autotype %s executable", name)
+	}
+	if len(customCommand) == 0 {
+		return nil, fmt.Errorf("the custom autotype %s executable is empty", name)
+	}
+	return exec.Command(customCommand[0], customCommand[1:]...), nil
+}
+
+// AutotypeSequence returns the autotype sequence of the entry,
+// inherited from its groups if not set, or the KeePass default one
+func (db *Database) AutotypeSequence(entry *Entry) string {
+	if entry.FullEntry.AutoType.DefaultSequence != "" {
+		return entry.FullEntry.AutoType.DefaultSequence
+	}
+	if sequence := groupAutotypeSequence(db.Keepass.Content.Root.Groups, entry.UUID, ""); sequence != "" {
+		return sequence
+	}
+	return AutotypeDefaultSequence
+}
+
+// groupAutotypeSequence looks for the entry into the groups and returns the nearest group sequence
+func groupAutotypeSequence(groups []gokeepasslib.Group, uuid gokeepasslib.UUID, inherited string) string {
+	for _, group := range groups {
+		sequence := inherited
+		if group.DefaultAutoTypeSequence != "" {
+			sequence = group.DefaultAutoTypeSequence
+		}
+		for _, e := range group.Entries {
+			if e.UUID.Compare(uuid) {
+				return sequence
+			}
+		}
+		if s := groupAutotypeSequence(group.Groups, uuid, sequence); s != "" {
+			return s
+		}
+	}
+	return ""
+}
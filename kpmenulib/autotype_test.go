@@ -0,0 +1,66 @@
+package kpmenulib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAutotypeSequence(t *testing.T) {
+	entry := newTestEntry("Title", "Entry", "UserName", "alice", "Password", "secret", "PIN", "1234")
+
+	tests := []struct {
+		sequence string
+		want     []AutotypeAction
+	}{
+		{"{USERNAME}{TAB}{PASSWORD}{ENTER}", []AutotypeAction{
+			{Text: "alice"}, {Key: "TAB"}, {Text: "secret"}, {Key: "ENTER"},
+		}},
+		{"user: {username} pin: {S:PIN}", []AutotypeAction{{Text: "user: alice pin: 1234"}}},
+		{"{TAB 3}", []AutotypeAction{{Key: "TAB"}, {Key: "TAB"}, {Key: "TAB"}}},
+		{"{tab}", []AutotypeAction{{Key: "TAB"}}},
+		{"a{DELAY 500}b", []AutotypeAction{{Text: "a"}, {Delay: 500 * time.Millisecond}, {Text: "b"}}},
+		{"{DELAY=100}a{TAB}", []AutotypeAction{
+			{Text: "a", Delay: 100 * time.Millisecond}, {Key: "TAB", Delay: 100 * time.Millisecond},
+		}},
+		{"^a", []AutotypeAction{{Key: "a", Modifiers: []string{"ctrl"}}}},
+		{"+^V", []AutotypeAction{{Key: "v", Modifiers: []string{"shift", "ctrl"}}}},
+		{"%{TAB}", []AutotypeAction{{Key: "TAB", Modifiers: []string{"alt"}}}},
+		{"^{USERNAME}", []AutotypeAction{{Key: "a", Modifiers: []string{"ctrl"}}, {Text: "lice"}}},
+		{"a~b", []AutotypeAction{{Text: "a"}, {Key: "ENTER"}, {Text: "b"}}},
+		{"{+}{^}{%}{~}{(}{)}{{}{}}", []AutotypeAction{{Text: "+^%~(){}"}}},
+		{"a{S:Missing}b", []AutotypeAction{{Text: "ab"}}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseAutotypeSequence(tt.sequence, entry)
+		if err != nil {
+			t.Errorf("ParseAutotypeSequence(%q) failed: %v", tt.sequence, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAutotypeSequence(%q) = %+v, want %+v", tt.sequence, got, tt.want)
+		}
+	}
+}
+
+func TestParseAutotypeSequenceErrors(t *testing.T) {
+	entry := newTestEntry("Title", "Entry", "UserName", "alice")
+
+	for _, sequence := range []string{
+		"{TAB",      // unclosed placeholder
+		"a{",        // unclosed placeholder at the end
+		"+(ab)",     // modifier group
+		"(ab)",      // group without modifiers
+		"^",         // modifier without a key
+		"{UNKNOWN}", // unknown placeholder
+		"{TAB x}",   // invalid repetition
+		"{DELAY x}", // invalid delay
+		"{DELAY=x}", // invalid default delay
+		"{TOTP}",    // entry without OTP
+	} {
+		if actions, err := ParseAutotypeSequence(sequence, entry); err == nil {
+			t.Errorf("ParseAutotypeSequence(%q) = %+v, want an error", sequence, actions)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Actions of the KeePassXC-Browser protocol
+const (
+	BrowserChangePublicKeys = "change-public-keys"
+	BrowserGetDatabaseHash  = "get-databasehash"
+	BrowserAssociate        = "associate"
+	BrowserTestAssociate    = "test-associate"
+	BrowserGetLogins        = "get-logins"
+	BrowserLockDatabase     = "lock-database"
+)
+
+// Error codes of the KeePassXC-Browser protocol
+const (
+	browserErrorDatabaseNotOpened    = 1
+	browserErrorPublicKeyNotReceived = 3
+	browserErrorCannotDecrypt        = 4
+	browserErrorActionDenied         = 6
+	browserErrorCannotEncrypt        = 7
+	browserErrorAssociationFailed    = 8
+	browserErrorIncorrectAction      = 12
+	browserErrorEmptyMessage         = 13
+	browserErrorNoURL                = 14
+	browserErrorNoLogins             = 15
+)
+
+var browserErrors = map[int]string{
+	browserErrorDatabaseNotOpened:    "Database not opened",
+	browserErrorPublicKeyNotReceived: "Client public key not received",
+	browserErrorCannotDecrypt:        "Cannot decrypt message",
+	browserErrorActionDenied:         "Action cancelled or denied",
+	browserErrorCannotEncrypt:        "Cannot encrypt message",
+	browserErrorAssociationFailed:    "KeePassXC association failed, try again",
+	browserErrorIncorrectAction:      "Incorrect action",
+	browserErrorEmptyMessage:         "Empty message received",
+	browserErrorNoURL:                "No URL provided",
+	browserErrorNoLogins:             "No logins found",
+}
+
+const (
+	// browserVersion is the KeePassXC version speaking the same protocol, checked by the extension
+	browserVersion = "2.7.0"
+	// browserKeyPrefix is the prefix of the database custom data keeping the association keys, as KeePassXC
+	browserKeyPrefix = "KPXC_BROWSER_"
+	// browserMaxMessage is the size limit of messages sent by the browser
+	browserMaxMessage = 64 * 1024 * 1024
+)
+
+// BrowserHost is the native messaging host of KeePassXC-Browser,
+// decrypted messages are executed by the running kpmenu
+type BrowserHost struct {
+	menu       *Menu
+	publicKey  *[32]byte // Key of the host, made for the session
+	privateKey *[32]byte
+	clientKey  *[32]byte            // Key of the browser extension
+	associated []browserAssociation // Associations verified in the session
+}
+
+// browserAssociation is the id and the key of an association
+type browserAssociation struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// browserRequest is a message sent by the browser, the message is encrypted except for change-public-keys
+type browserRequest struct {
+	Action        string `json:"action"`
+	Message       string `json:"message"`
+	Nonce         string `json:"nonce"`
+	ClientID      string `json:"clientID"`
+	PublicKey     string `json:"publicKey"`
+	TriggerUnlock string `json:"triggerUnlock"`
+}
+
+// browserMessage is a decrypted message of the browser, executed by the running kpmenu
+type browserMessage struct {
+	Action        string               `json:"action"`
+	TriggerUnlock string               `json:"triggerUnlock"`
+	Key           string               `json:"key"`   // Public key of the client for associate, association key for test-associate
+	IDKey         string               `json:"idKey"` // Association key for associate
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Keys          []browserAssociation `json:"keys"`
+}
+
+// browserLogin is an entry sent to the browser by get-logins
+type browserLogin struct {
+	Login        string              `json:"login"`
+	Name         string              `json:"name"`
+	Password     string              `json:"password"`
+	UUID         string              `json:"uuid"`
+	Group        string              `json:"group"`
+	TOTP         string              `json:"totp,omitempty"`
+	Expired      string              `json:"expired,omitempty"`
+	StringFields []map[string]string `json:"stringFields"`
+}
+
+// StartBrowserHost talks with the browser on the standard input and output until it closes
+// Returns the exit code
+func StartBrowserHost(m *Menu) int {
+	h := &BrowserHost{menu: m}
+	for {
+		data, err := readNativeMessage(os.Stdin)
+		if err == io.EOF {
+			return ExitOK
+		} else if err != nil {
+			log.Printf("failed to read browser message: %v", err)
+			return ExitError
+		}
+		if err := writeNativeMessage(os.Stdout, h.handle(data)); err != nil {
+			log.Printf("failed to send browser message: %v", err)
+			return ExitError
+		}
+	}
+}
+
+// handle returns the response to the message of the browser
+func (h *BrowserHost) handle(data []byte) map[string]interface{} {
+	var request browserRequest
+	if err := json.Unmarshal(data, &request); err != nil || request.Action == "" {
+		return browserError("", browserErrorEmptyMessage)
+	}
+	nonce, err := decodeBrowserKey(request.Nonce, 24)
+	if err != nil {
+		return browserError(request.Action, browserErrorCannotDecrypt)
+	}
+	var nonceArray [24]byte
+	copy(nonceArray[:], nonce)
+	responseNonce := incrementNonce(nonceArray)
+
+	if request.Action == BrowserChangePublicKeys {
+		// Keys are changed every time the extension connects
+		key, err := decodeBrowserKey(request.PublicKey, 32)
+		if err != nil {
+			return browserError(request.Action, browserErrorPublicKeyNotReceived)
+		}
+		publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Printf("failed to generate browser keys: %v", err)
+			return browserError(request.Action, browserErrorCannotEncrypt)
+		}
+		h.publicKey, h.privateKey, h.clientKey = publicKey, privateKey, new([32]byte)
+		copy(h.clientKey[:], key)
+		return map[string]interface{}{
+			"action":    request.Action,
+			"version":   browserVersion,
+			"publicKey": base64.StdEncoding.EncodeToString(publicKey[:]),
+			"nonce":     base64.StdEncoding.EncodeToString(responseNonce[:]),
+			"success":   "true",
+		}
+	}
+
+	if h.clientKey == nil {
+		return browserError(request.Action, browserErrorPublicKeyNotReceived)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(request.Message)
+	if err != nil {
+		return browserError(request.Action, browserErrorCannotDecrypt)
+	}
+	decrypted, ok := box.Open(nil, encrypted, &nonceArray, h.clientKey, h.privateKey)
+	if !ok {
+		return browserError(request.Action, browserErrorCannotDecrypt)
+	}
+	var message browserMessage
+	if err := json.Unmarshal(decrypted, &message); err != nil {
+		return browserError(request.Action, browserErrorEmptyMessage)
+	}
+	if message.Action == BrowserAssociate && message.Key != base64.StdEncoding.EncodeToString(h.clientKey[:]) {
+		// Only the extension of this session can be associated
+		return browserError(request.Action, browserErrorAssociationFailed)
+	}
+	if message.Action == BrowserLockDatabase {
+		// The browser doesn't send its keys, the associations verified in the session are used
+		message.Keys = h.associated
+	}
+	if request.TriggerUnlock == "true" {
+		message.TriggerUnlock = request.TriggerUnlock
+	}
+	input, err := json.Marshal(message)
+	if err != nil {
+		return browserError(request.Action, browserErrorEmptyMessage)
+	}
+
+	// Execute the message with the running kpmenu, the arguments given by the browser are not sent,
+	// the running kpmenu would parse them
+	reply, err := sendPacket(Packet{
+		Request:      RequestShow,
+		CliArguments: []string{CommandBrowserHost},
+		Input:        string(input),
+	})
+	if err != nil {
+		log.Print(err)
+		return browserError(request.Action, browserErrorDatabaseNotOpened)
+	}
+	if reply.ExitCode != ExitOK {
+		log.Print(reply.Error)
+		return browserError(request.Action, browserErrorDatabaseNotOpened)
+	}
+	response := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(reply.Value), &response); err != nil {
+		log.Printf("invalid browser response: %v", err)
+		return browserError(request.Action, browserErrorIncorrectAction)
+	}
+	if code, ok := response["errorCode"].(float64); ok {
+		return browserError(request.Action, int(code))
+	}
+	switch message.Action {
+	case BrowserAssociate:
+		id, _ := response["id"].(string)
+		h.associated = append(h.associated, browserAssociation{ID: id, Key: message.IDKey})
+	case BrowserTestAssociate:
+		h.associated = append(h.associated, browserAssociation{ID: message.ID, Key: message.Key})
+	}
+
+	response["version"] = browserVersion
+	response["nonce"] = base64.StdEncoding.EncodeToString(responseNonce[:])
+	response["success"] = "true"
+	data, err = json.Marshal(response)
+	if err != nil {
+		return browserError(request.Action, browserErrorCannotEncrypt)
+	}
+	return map[string]interface{}{
+		"action":  request.Action,
+		"message": base64.StdEncoding.EncodeToString(box.Seal(nil, data, &responseNonce, h.clientKey, h.privateKey)),
+		"nonce":   base64.StdEncoding.EncodeToString(responseNonce[:]),
+	}
+}
+
+// browserAction executes the decrypted message of the browser, given as input,
+// and prints the response for the browser host
+func (m *Menu) browserAction() *ErrorDatabase {
+	var message browserMessage
+	if err := json.Unmarshal([]byte(m.Input), &message); err != nil {
+		return NewErrorDatabase("invalid browser message: %s", err, false)
+	}
+
+	var response interface{}
+	if !m.Database.Loaded && message.TriggerUnlock == "true" {
+		if err := m.OpenDatabase(); err != nil {
+			log.Print(err)
+		} else if !m.Configuration.Flags.Daemon {
+			// Unlocked by the user, the cache starts now
+			m.CacheStart = time.Now()
+		}
+	}
+	if !m.Database.Loaded {
+		// The browser shows the database as locked
+		response = browserErrorCode(browserErrorDatabaseNotOpened)
+	} else {
+		response = m.browserResponse(message)
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return NewErrorDatabase("failed to encode browser response: %s", err, false)
+	}
+	if _, err := fmt.Fprintln(m.Output, string(data)); err != nil {
+		return NewErrorDatabase("failed to print browser response: %s", err, false)
+	}
+	return nil
+}
+
+// browserResponse returns the response to the message, or its error code
+func (m *Menu) browserResponse(message browserMessage) interface{} {
+	hash := m.browserDatabaseHash()
+	switch message.Action {
+	case BrowserGetDatabaseHash:
+		return map[string]string{"hash": hash}
+	case BrowserAssociate:
+		if _, err := decodeBrowserKey(message.IDKey, 32); err != nil {
+			return browserErrorCode(browserErrorAssociationFailed)
+		}
+		// The user confirms the association by naming it
+		id, err := PromptInput(m, "Browser association name")
+		if err.Cancelled || err.Error != nil || id == "" {
+			if err.Error != nil {
+				log.Printf("failed to get association name: %v", err.Error)
+			}
+			return browserErrorCode(browserErrorActionDenied)
+		}
+		if err := m.storeBrowserKey(id, message.IDKey); err != nil {
+			log.Printf("failed to store association %s: %v", id, err)
+			return browserErrorCode(browserErrorAssociationFailed)
+		}
+		log.Printf("associated browser %s", id)
+		return map[string]string{"hash": hash, "id": id}
+	case BrowserTestAssociate:
+		if !m.browserAssociated(message.ID, message.Key) {
+			return browserErrorCode(browserErrorAssociationFailed)
+		}
+		return map[string]string{"hash": hash, "id": message.ID}
+	case BrowserGetLogins:
+		if !m.browserKeysAssociated(message.Keys) {
+			return browserErrorCode(browserErrorAssociationFailed)
+		}
+		if message.URL == "" {
+			return browserErrorCode(browserErrorNoURL)
+		}
+		logins := []browserLogin{}
+		for i := range m.Database.Entries {
+			e := &m.Database.Entries[i]
+			if matchURL(e.FullEntry.GetContent("URL"), message.URL) {
+				logins = append(logins, m.browserLogin(e))
+			}
+		}
+		if len(logins) == 0 {
+			return browserErrorCode(browserErrorNoLogins)
+		}
+		log.Printf("sent %d logins to the browser for %s", len(logins), urlHost(message.URL))
+		return map[string]interface{}{"count": len(logins), "entries": logins, "hash": hash, "id": ""}
+	case BrowserLockDatabase:
+		if !m.browserKeysAssociated(message.Keys) {
+			return browserErrorCode(browserErrorAssociationFailed)
+		}
+		m.lockDatabase()
+		log.Printf("database locked by the browser")
+		return browserErrorCode(browserErrorDatabaseNotOpened)
+	}
+	return browserErrorCode(browserErrorIncorrectAction)
+}
+
+// browserLogin returns the entry as login of get-logins
+func (m *Menu) browserLogin(e *Entry) browserLogin {
+	login := browserLogin{
+		Login:        m.Database.EntryValue(e, "UserName"),
+		Name:         e.FullEntry.GetTitle(),
+		Password:     m.Database.EntryValue(e, "Password"),
+		UUID:         e.HexUUID(),
+		Group:        e.Path,
+		StringFields: []map[string]string{},
+	}
+	if e.Expired() {
+		login.Expired = "true"
+	}
+	if !m.Configuration.General.NoOTP && HasOTP(e.FullEntry) {
+		// HOTP codes are not sent, every login request would use one
+		if otpa, err := CreateOTPAuth(e.FullEntry); err == nil && otpa.Type != HOTP {
+			if otp, err := otpa.Create(time.Now().Unix()); err == nil {
+				login.TOTP = otp
+			}
+		}
+	}
+	return login
+}
+
+// browserDatabaseHash returns the hash identifying the database, as KeePassXC
+func (m *Menu) browserDatabaseHash() string {
+	root := m.Database.RootGroup()
+	hash := sha256.Sum256([]byte(hex.EncodeToString(root.UUID[:])))
+	return hex.EncodeToString(hash[:])
+}
+
+// browserAssociated checks if the association key is stored into the database
+func (m *Menu) browserAssociated(id string, key string) bool {
+	if id == "" || key == "" {
+		return false
+	}
+	for _, data := range m.Database.Keepass.Content.Meta.CustomData {
+		if data.Key == browserKeyPrefix+id {
+			return data.Value == key
+		}
+	}
+	return false
+}
+
+// browserKeysAssociated checks if any of the association keys is stored into the database
+func (m *Menu) browserKeysAssociated(keys []browserAssociation) bool {
+	for _, key := range keys {
+		if m.browserAssociated(key.ID, key.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeBrowserKey stores the association key into the database, replacing the one with the same id
+func (m *Menu) storeBrowserKey(id string, key string) error {
+	meta := m.Database.Keepass.Content.Meta
+	previous := meta.CustomData
+	customData := []gokeepasslib.CustomData{{Key: browserKeyPrefix + id, Value: key}}
+	for _, data := range previous {
+		if data.Key != browserKeyPrefix+id {
+			customData = append(customData, data)
+		}
+	}
+	meta.CustomData = customData
+	if err := m.Database.SaveDatabase(m.Configuration); err != nil {
+		// Restore the keys, they are not saved
+		meta.CustomData = previous
+		return err
+	}
+	return nil
+}
+
+// browserErrorCode is the response of a failed message, the error is added by the browser host
+func browserErrorCode(code int) map[string]int {
+	return map[string]int{"errorCode": code}
+}
+
+// browserError returns the error response sent to the browser
+func browserError(action string, code int) map[string]interface{} {
+	return map[string]interface{}{
+		"action":    action,
+		"errorCode": strconv.Itoa(code),
+		"error":     browserErrors[code],
+	}
+}
+
+// decodeBrowserKey decodes a base64 key or nonce of the given size
+func decodeBrowserKey(s string, size int) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("invalid size %d", len(data))
+	}
+	return data, nil
+}
+
+// incrementNonce returns the nonce incremented by one as little endian number, as libsodium
+func incrementNonce(nonce [24]byte) [24]byte {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			break
+		}
+	}
+	return nonce
+}
+
+// readNativeMessage reads a message of the browser, prefixed by its length in native byte order
+func readNativeMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > browserMaxMessage {
+		return nil, fmt.Errorf("message too long (%d bytes)", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeNativeMessage writes the message for the browser, prefixed by its length in native byte order
+func writeNativeMessage(w io.Writer, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, uint32(len(data)))
+	buffer.Write(data)
+	_, err = w.Write(buffer.Bytes())
+	return err
+}
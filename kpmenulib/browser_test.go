@@ -0,0 +1,279 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// testBrowser is the browser extension talking with a browser host
+type testBrowser struct {
+	host       *BrowserHost
+	publicKey  *[32]byte
+	privateKey *[32]byte
+	hostKey    *[32]byte
+}
+
+// newTestBrowser makes a browser that changed its public keys with the host
+func newTestBrowser(t *testing.T) *testBrowser {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBrowser{host: &BrowserHost{menu: NewMenu()}, publicKey: publicKey, privateKey: privateKey}
+
+	var nonce [24]byte
+	rand.Read(nonce[:])
+	response := b.send(t, map[string]string{
+		"action":    BrowserChangePublicKeys,
+		"publicKey": base64.StdEncoding.EncodeToString(publicKey[:]),
+		"nonce":     base64.StdEncoding.EncodeToString(nonce[:]),
+		"clientID":  "client",
+	})
+	if response["success"] != "true" {
+		t.Fatalf("change-public-keys failed: %v", response)
+	}
+	if want := incrementNonce(nonce); response["nonce"] != base64.StdEncoding.EncodeToString(want[:]) {
+		t.Errorf("change-public-keys nonce %v, want the incremented nonce", response["nonce"])
+	}
+	key, err := decodeBrowserKey(response["publicKey"].(string), 32)
+	if err != nil {
+		t.Fatalf("invalid host public key: %v", err)
+	}
+	b.hostKey = new([32]byte)
+	copy(b.hostKey[:], key)
+	return b
+}
+
+// send sends the request to the host and returns its response
+func (b *testBrowser) send(t *testing.T, request interface{}) map[string]interface{} {
+	data, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(b.host.handle(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := make(map[string]interface{})
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// sendMessage encrypts the message for the host and returns the decrypted response,
+// or the error response if not encrypted
+func (b *testBrowser) sendMessage(t *testing.T, message map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	rand.Read(nonce[:])
+	response := b.send(t, map[string]string{
+		"action":   message["action"].(string),
+		"message":  base64.StdEncoding.EncodeToString(box.Seal(nil, data, &nonce, b.hostKey, b.privateKey)),
+		"nonce":    base64.StdEncoding.EncodeToString(nonce[:]),
+		"clientID": "client",
+	})
+	if _, ok := response["message"]; !ok {
+		return response
+	}
+
+	// The response is encrypted with the incremented nonce
+	want := incrementNonce(nonce)
+	if response["nonce"] != base64.StdEncoding.EncodeToString(want[:]) {
+		t.Errorf("response nonce %v, want the incremented nonce", response["nonce"])
+	}
+	encrypted, _ := base64.StdEncoding.DecodeString(response["message"].(string))
+	decrypted, ok := box.Open(nil, encrypted, &want, b.hostKey, b.privateKey)
+	if !ok {
+		t.Fatal("failed to decrypt the response")
+	}
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal(decrypted, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["nonce"] != response["nonce"] {
+		t.Errorf("encrypted nonce %v, want %v", decoded["nonce"], response["nonce"])
+	}
+	return decoded
+}
+
+// browserErrorCodeOf returns the error code of the response, 0 if it succeeded
+func browserErrorCodeOf(response map[string]interface{}) int {
+	switch code := response["errorCode"].(type) {
+	case string:
+		i, _ := strconv.Atoi(code)
+		return i
+	case float64:
+		return int(code)
+	}
+	return 0
+}
+
+func TestIncrementNonce(t *testing.T) {
+	tests := []struct {
+		nonce [24]byte
+		want  [24]byte
+	}{
+		{[24]byte{}, [24]byte{1}},
+		{[24]byte{1, 2}, [24]byte{2, 2}},
+		{[24]byte{255, 0, 3}, [24]byte{0, 1, 3}},
+		{[24]byte{255, 255, 3}, [24]byte{0, 0, 4}},
+	}
+	for _, tt := range tests {
+		if got := incrementNonce(tt.nonce); got != tt.want {
+			t.Errorf("incrementNonce(%v) = %v, want %v", tt.nonce, got, tt.want)
+		}
+	}
+
+	var max [24]byte
+	for i := range max {
+		max[i] = 255
+	}
+	if got := incrementNonce(max); got != [24]byte{} {
+		t.Errorf("incrementNonce(max) = %v, want zero", got)
+	}
+}
+
+func TestBrowserHostHandle(t *testing.T) {
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, 24))
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		name    string
+		request map[string]string
+		want    int
+	}{
+		{"empty", map[string]string{}, browserErrorEmptyMessage},
+		{"nonce missing", map[string]string{"action": BrowserChangePublicKeys, "publicKey": key}, browserErrorCannotDecrypt},
+		{"nonce too short", map[string]string{"action": BrowserChangePublicKeys, "publicKey": key, "nonce": base64.StdEncoding.EncodeToString(make([]byte, 23))}, browserErrorCannotDecrypt},
+		{"public key missing", map[string]string{"action": BrowserChangePublicKeys, "nonce": nonce}, browserErrorPublicKeyNotReceived},
+		{"public key too short", map[string]string{"action": BrowserChangePublicKeys, "nonce": nonce, "publicKey": base64.StdEncoding.EncodeToString(make([]byte, 31))}, browserErrorPublicKeyNotReceived},
+		{"keys not changed", map[string]string{"action": BrowserGetDatabaseHash, "nonce": nonce, "message": "bWVzc2FnZQ=="}, browserErrorPublicKeyNotReceived},
+	}
+	for _, tt := range tests {
+		b := &testBrowser{host: &BrowserHost{menu: NewMenu()}}
+		if got := browserErrorCodeOf(b.send(t, tt.request)); got != tt.want {
+			t.Errorf("%s: error code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Messages not encrypted for the host are refused
+	b := newTestBrowser(t)
+	other, otherPrivate, _ := box.GenerateKey(rand.Reader)
+	var n [24]byte
+	sealed := box.Seal(nil, []byte(`{"action":"get-databasehash"}`), &n, other, otherPrivate)
+	response := b.send(t, map[string]string{
+		"action":  BrowserGetDatabaseHash,
+		"message": base64.StdEncoding.EncodeToString(sealed),
+		"nonce":   base64.StdEncoding.EncodeToString(n[:]),
+	})
+	if got := browserErrorCodeOf(response); got != browserErrorCannotDecrypt {
+		t.Errorf("message of another key: error code %d, want %d", got, browserErrorCannotDecrypt)
+	}
+
+	// Only the public key of the session can be associated
+	response = b.sendMessage(t, map[string]interface{}{
+		"action": BrowserAssociate,
+		"key":    base64.StdEncoding.EncodeToString(other[:]),
+		"idKey":  key,
+	})
+	if got := browserErrorCodeOf(response); got != browserErrorAssociationFailed {
+		t.Errorf("associate with another key: error code %d, want %d", got, browserErrorAssociationFailed)
+	}
+}
+
+func TestBrowserHostServer(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	m := NewMenu()
+	m.Database = newTestDatabase(newTestEntry("Title", "Forum", "URL", "https://forum.example.com"))
+	startTestServer(t, func(packet Packet) (Reply, bool) {
+		// The arguments of the browser are not sent
+		if want := []string{CommandBrowserHost}; !reflect.DeepEqual(packet.CliArguments, want) {
+			t.Errorf("sent arguments %q, want %q", packet.CliArguments, want)
+		}
+		var output bytes.Buffer
+		m.Input, m.Output = packet.Input, &output
+		if err := m.browserAction(); err != nil {
+			return Reply{ExitCode: ExitError, Error: err.String()}, false
+		}
+		return Reply{ExitCode: ExitOK, Value: output.String()}, false
+	})
+
+	b := newTestBrowser(t)
+	b.host.menu.CliArguments = []string{"browser-host", "/usr/lib/mozilla/native-messaging-hosts/kpmenu.json", "kpmenu@example.com"}
+	response := b.sendMessage(t, map[string]interface{}{"action": BrowserGetDatabaseHash})
+	if response["hash"] != m.browserDatabaseHash() || response["success"] != "true" {
+		t.Errorf("get-databasehash = %v, want hash %s", response, m.browserDatabaseHash())
+	}
+
+	// Logins are not sent to unassociated browsers
+	response = b.sendMessage(t, map[string]interface{}{
+		"action": BrowserGetLogins,
+		"url":    "https://forum.example.com",
+		"keys":   []browserAssociation{{ID: "unknown", Key: "key"}},
+	})
+	if got := browserErrorCodeOf(response); got != browserErrorAssociationFailed {
+		t.Errorf("get-logins without association: error code %d, want %d", got, browserErrorAssociationFailed)
+	}
+}
+
+func TestBrowserResponse(t *testing.T) {
+	m := NewMenu()
+	m.Database = newTestDatabase(
+		newTestEntry("Title", "Forum", "UserName", "alice", "Password", "forumpw", "URL", "https://forum.example.com"),
+		newTestEntry("Title", "Bank", "UserName", "bob", "Password", "bankpw", "URL", "https://bank.example.com"),
+	)
+	idKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	m.Database.Keepass.Content.Meta.CustomData = []gokeepasslib.CustomData{{Key: browserKeyPrefix + "Firefox", Value: idKey}}
+	associated := []browserAssociation{{ID: "Firefox", Key: idKey}}
+
+	tests := []struct {
+		name    string
+		message browserMessage
+		want    int
+	}{
+		{"associate with an invalid key", browserMessage{Action: BrowserAssociate, IDKey: "short"}, browserErrorAssociationFailed},
+		{"associate denied", browserMessage{Action: BrowserAssociate, IDKey: idKey}, browserErrorActionDenied},
+		{"test-associate", browserMessage{Action: BrowserTestAssociate, ID: "Firefox", Key: idKey}, 0},
+		{"test-associate with a wrong key", browserMessage{Action: BrowserTestAssociate, ID: "Firefox", Key: "wrong"}, browserErrorAssociationFailed},
+		{"test-associate with an unknown id", browserMessage{Action: BrowserTestAssociate, ID: "Chrome", Key: idKey}, browserErrorAssociationFailed},
+		{"test-associate without key", browserMessage{Action: BrowserTestAssociate, ID: "Firefox"}, browserErrorAssociationFailed},
+		{"get-logins without keys", browserMessage{Action: BrowserGetLogins, URL: "https://forum.example.com"}, browserErrorAssociationFailed},
+		{"get-logins with a wrong key", browserMessage{Action: BrowserGetLogins, URL: "https://forum.example.com", Keys: []browserAssociation{{ID: "Firefox", Key: "wrong"}}}, browserErrorAssociationFailed},
+		{"get-logins without URL", browserMessage{Action: BrowserGetLogins, Keys: associated}, browserErrorNoURL},
+		{"get-logins without logins", browserMessage{Action: BrowserGetLogins, URL: "https://other.example.com", Keys: associated}, browserErrorNoLogins},
+		{"lock-database without keys", browserMessage{Action: BrowserLockDatabase}, browserErrorAssociationFailed},
+		{"unknown action", browserMessage{Action: "unknown"}, browserErrorIncorrectAction},
+	}
+	for _, tt := range tests {
+		// The association name is not given
+		m.Prompter = &fakePrompter{}
+		response, ok := m.browserResponse(tt.message).(map[string]int)
+		got := 0
+		if ok {
+			got = response["errorCode"]
+		}
+		if got != tt.want {
+			t.Errorf("%s: error code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	response, ok := m.browserResponse(browserMessage{Action: BrowserGetLogins, URL: "https://forum.example.com/login", Keys: associated}).(map[string]interface{})
+	if !ok {
+		t.Fatalf("get-logins failed: %v", response)
+	}
+	logins, _ := response["entries"].([]browserLogin)
+	if len(logins) != 1 || logins[0].Login != "alice" || logins[0].Password != "forumpw" {
+		t.Errorf("get-logins = %+v, want the login of Forum", logins)
+	}
+}
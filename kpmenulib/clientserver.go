@@ -1,7 +1,12 @@
 package kpmenulib
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,31 +14,156 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// RequestType is an enum used for the request sent by the client
+type RequestType int
+
+// RequestType enum values
+const (
+	RequestShow   = RequestType(iota) // Show the menu
+	RequestLock                       // Drop the decrypted database and credentials
+	RequestStatus                     // Get the status of the server
+	RequestReload                     // Reload the database
+	RequestQuit                       // Stop the server
+)
+
+// Exit codes of the client
+const (
+	ExitOK         = 0 // Request executed
+	ExitError      = 1 // Request failed
+	ExitLocked     = 2 // The database is locked
+	ExitNotRunning = 3 // The server is not running
+)
+
 // Packet is the data sent by the client to the server listener
 type Packet struct {
+	Request      RequestType
 	CliArguments []string
+	Input        string // Standard input of commands
+	Token        string // Authentication token, used only by the loopback listener
 }
 
-// StartClient sends a packet to the server listener
-func StartClient() error {
-	port, err := getPort()
-	if err != nil {
-		return err
+// Reply is the data sent by the server to the client, once the request is handled
+type Reply struct {
+	ExitCode int           // Exit code of the client
+	Message  string        // Message printed by the client
+	Error    string        // Error printed by the client on stderr
+	Value    string        // Selected value, printed by the client with the stdout clipboard tool
+	Status   *ServerStatus // Status of the server, for status requests
+}
+
+// ServerStatus is the status of the server
+type ServerStatus struct {
+	Locked         bool   // The database is not loaded
+	Database       string // Path of the database
+	CacheRemaining int    // Seconds before the cache times out, -1 if it doesn't time out
+}
+
+func (s ServerStatus) String() string {
+	state := "unlocked"
+	if s.Locked {
+		state = "locked"
+	}
+	cache := "no timeout"
+	if s.CacheRemaining >= 0 {
+		cache = fmt.Sprintf("%ds", s.CacheRemaining)
 	}
+	return fmt.Sprintf("status: %s\ndatabase: %s\ncache remaining: %s", state, s.Database, cache)
+}
+
+// ErrNotRunning is returned by the client if the server is not running
+var ErrNotRunning = errors.New("kpmenu is not running")
+
+// Request returns the request of the given flags
+func (f Flags) Request() RequestType {
+	switch {
+	case f.Lock:
+		return RequestLock
+	case f.Status:
+		return RequestStatus
+	case f.Reload:
+		return RequestReload
+	case f.Quit:
+		return RequestQuit
+	}
+	return RequestShow
+}
 
-	conn, err := net.Dial("tcp", "localhost:"+port)
+// deadlineListener is a listener that supports accept deadlines, as TCP and Unix listeners
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+// StartClient sends a packet to the server listener and waits for its reply
+// Returns the exit code of the client, or ErrNotRunning if the server is not running
+func StartClient(m *Menu) (int, error) {
+	reply, err := sendPacket(Packet{
+		Request:      m.Configuration.Flags.Request(),
+		CliArguments: os.Args[1:],
+		Input:        m.Input,
+	})
+	if err == ErrNotRunning {
+		return ExitNotRunning, err
+	} else if err != nil {
+		log.Print(err)
+		return ExitError, nil
+	}
+	if reply.Status != nil {
+		fmt.Println(reply.Status)
+	}
+	if reply.Message != "" {
+		fmt.Println(reply.Message)
+	}
+	if reply.Value != "" {
+		fmt.Fprintln(m.Output, reply.Value)
+	}
+	if reply.Error != "" {
+		fmt.Fprintln(os.Stderr, reply.Error)
+	}
+	return reply.ExitCode, nil
+}
+
+// sendPacket sends the packet to the server listener and returns its reply,
+// ErrNotRunning if the server is not running
+func sendPacket(packet Packet) (Reply, error) {
+	var reply Reply
+	conn, token, err := dialServer()
 	if err != nil {
-		return err
+		return reply, ErrNotRunning
 	}
 	defer conn.Close()
 
 	// Send the packet
-	enc := gob.NewEncoder(conn)
-	err = enc.Encode(Packet{CliArguments: os.Args[1:]})
-	return err
+	packet.Token = token
+	if err := gob.NewEncoder(conn).Encode(packet); err != nil {
+		return reply, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	// Wait for the reply
+	if err := gob.NewDecoder(conn).Decode(&reply); err != nil {
+		return reply, fmt.Errorf("failed to receive reply: %v", err)
+	}
+	return reply, nil
+}
+
+// dialServer connects to the server, via the Unix socket if available otherwise via loopback
+// Returns the token to authenticate with
+func dialServer() (net.Conn, string, error) {
+	if path := getSocketPath(); path != "" {
+		conn, err := net.Dial("unix", path)
+		return conn, "", err
+	}
+
+	port, token, err := getServerAuth()
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	return conn, token, err
 }
 
 // StartServer starts to listen for client packets
@@ -42,23 +172,50 @@ func StartServer(m *Menu) (err error) {
 		log.Printf("Executing as daemon")
 	}
 
-	if m.Configuration.General.NoCache && !m.Configuration.Flags.Daemon {
+	if m.Configuration.Flags.Command != "" && !m.Configuration.Flags.Daemon {
+		// Directly execute the command, scripts wait for kpmenu to exit
+		if err := Execute(m); err != nil {
+			os.Exit(ExitError)
+		}
+	} else if m.Configuration.General.NoCache && !m.Configuration.Flags.Daemon {
 		// Directly execute kpmenu
-		if fatal := Execute(m); fatal == true {
+		if err := Execute(m); err != nil && err.Fatal {
 			os.Exit(1) // Set exit code to 1 and exit
 		}
 	} else {
 		// Handle packet request
-		handlePacket := func(packet Packet) bool {
-			log.Printf("received a client call with args \"%v\"", packet.CliArguments)
-			m.CliArguments = packet.CliArguments
-			return Show(m)
+		handlePacket := func(packet Packet) (Reply, bool) {
+			return handleRequest(m, packet)
+		}
+
+		// Serve the SSH keys while the database is cached
+		if m.Configuration.General.SSHAgent {
+			sshAgent, err := StartSSHAgent(getSSHAgentSocketPath(m))
+			if err != nil {
+				log.Print(err)
+			} else {
+				m.SSHAgent = sshAgent
+				defer sshAgent.Close()
+			}
+		}
+
+		// Expose the database as Secret Service
+		if m.Configuration.General.SecretService {
+			secretService, err := StartSecretService(m)
+			if err != nil {
+				log.Print(err)
+			} else {
+				defer secretService.Close()
+			}
 		}
 
 		// Execute kpmenu for the first time, if not a daemon
 		exit := false
 		if !m.Configuration.Flags.Daemon {
-			exit = Execute(m)
+			m.Mutex.Lock()
+			err := Execute(m)
+			m.Mutex.Unlock()
+			exit = err != nil && err.Fatal
 		}
 
 		// If exit is false (cache on) listen for client calls
@@ -69,29 +226,77 @@ func StartServer(m *Menu) (err error) {
 	return
 }
 
-func setupListener(m *Menu, handlePacket func(Packet) bool) error {
-	// Listen for client calls
-	listener, err := net.Listen("tcp", ":0")
-	if err != nil {
-		return err
+// handleRequest executes the request of the client
+// Returns the reply and true if the server should exit
+func handleRequest(m *Menu, packet Packet) (Reply, bool) {
+	switch packet.Request {
+	case RequestLock:
+		log.Printf("received a lock request")
+		m.lockDatabase()
+		// Without a daemon there is nothing left to cache
+		return Reply{ExitCode: ExitOK, Message: "database locked"}, !m.Configuration.Flags.Daemon
+	case RequestStatus:
+		status := ServerStatus{
+			Locked:         !m.Database.Loaded,
+			Database:       m.Configuration.Database.Database,
+			CacheRemaining: -1,
+		}
+		if m.cacheTimesOut() && !status.Locked {
+			status.CacheRemaining = m.Configuration.General.CacheTimeout - int(time.Now().Sub(m.CacheStart).Seconds())
+		}
+		reply := Reply{ExitCode: ExitOK, Status: &status}
+		if status.Locked {
+			reply.ExitCode = ExitLocked
+		}
+		return reply, false
+	case RequestReload:
+		log.Printf("received a reload request")
+		if !m.Database.Loaded {
+			return Reply{ExitCode: ExitLocked, Error: "database is locked"}, false
+		}
+		if err := m.OpenDatabase(); err != nil {
+			log.Print(err)
+			return Reply{ExitCode: ExitError, Error: err.String()}, err.Fatal && !m.Configuration.Flags.Daemon
+		}
+		return Reply{ExitCode: ExitOK, Message: "database reloaded"}, false
+	case RequestQuit:
+		log.Printf("received a quit request")
+		return Reply{ExitCode: ExitOK, Message: "kpmenu stopped"}, true
 	}
-	tcpListener := listener.(*net.TCPListener)
-	defer tcpListener.Close()
 
-	// Get used port
-	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	log.Printf("received a client call with args \"%v\"", packet.CliArguments)
+	m.CliArguments = packet.CliArguments
+	m.Input = packet.Input
+
+	// Values printed by the stdout clipboard tool are sent back to the client
+	var output bytes.Buffer
+	m.Output = &output
+	err := Show(m)
+	m.Output = os.Stdout
 
-	// Save port
-	if err := savePort(port); err != nil {
+	reply := Reply{ExitCode: ExitOK, Value: strings.TrimSuffix(output.String(), "\n")}
+	if err != nil {
+		reply.ExitCode = ExitError
+		reply.Error = err.String()
+		return reply, err.Fatal && !m.Configuration.Flags.Daemon
+	}
+	return reply, false
+}
+
+func setupListener(m *Menu, handlePacket func(Packet) (Reply, bool)) error {
+	// Listen for client calls
+	listener, token, err := listen()
+	if err != nil {
 		return err
 	}
+	defer listener.Close()
 
 	exit := false
 	for !exit {
 		if !m.Configuration.Flags.Daemon {
 			// If not a daemon prepare cache time
 			remainingCacheTime := m.Configuration.General.CacheTimeout - int(time.Now().Sub(m.CacheStart).Seconds())
-			tcpListener.SetDeadline(time.Now().Add(time.Second * time.Duration(remainingCacheTime)))
+			listener.SetDeadline(time.Now().Add(time.Second * time.Duration(remainingCacheTime)))
 		}
 
 		// Listen to calls
@@ -104,11 +309,17 @@ func setupListener(m *Menu, handlePacket func(Packet) bool) error {
 			}
 			return err
 		}
-		defer conn.Close()
 
-		// Go routine to handle input
-		ch := make(chan Packet)
-		errCh := make(chan error)
+		// Only the same user can talk with the server
+		if err := checkPeer(conn); err != nil {
+			log.Printf("refused client call: %v", err)
+			conn.Close()
+			continue
+		}
+
+		// Go routine to handle input, the channels are buffered to not block it when timed out
+		ch := make(chan Packet, 1)
+		errCh := make(chan error, 1)
 		go func(ch chan Packet, errCh chan error) {
 			dec := gob.NewDecoder(conn)
 			var packet Packet
@@ -116,54 +327,126 @@ func setupListener(m *Menu, handlePacket func(Packet) bool) error {
 			if err != nil {
 				if err != io.EOF {
 					errCh <- err
-				} else {
-					return
 				}
+				return
 			}
 			ch <- packet
 		}(ch, errCh)
 
-		// Handle received input
-		timeout := time.Tick(3 * time.Second) // Timeout of 3 seconds - to avoid problems
+		// Handle received input, the connection is closed in every case
+		timeout := time.After(3 * time.Second) // Timeout of 3 seconds - to avoid problems
 		select {
 		case packet := <-ch:
 			// Received the data
-			fatal := handlePacket(packet)
-			exit = (fatal && !m.Configuration.Flags.Daemon)
-			break
+			if token != "" && subtle.ConstantTimeCompare([]byte(packet.Token), []byte(token)) != 1 {
+				log.Printf("refused client call: invalid token")
+				conn.Close()
+				break
+			}
+			var reply Reply
+			m.Mutex.Lock()
+			reply, exit = handlePacket(packet)
+			m.Mutex.Unlock()
+			if err := gob.NewEncoder(conn).Encode(reply); err != nil {
+				log.Printf("failed to send reply: %v", err)
+			}
+			conn.Close()
 		case err := <-errCh:
-			// Received an error
-			return err
+			// Received an invalid packet, keep listening
+			log.Printf("failed to decode client call: %v", err)
+			conn.Close()
 		case <-timeout:
-			// Timed out
+			// Timed out, closing the connection stops the go routine
 			log.Printf("received request is timed out")
+			conn.Close()
 		}
 	}
 
 	return nil
 }
 
+// listen starts the listener on the Unix socket if $XDG_RUNTIME_DIR is set,
+// otherwise on a loopback port with a random token saved into the cache folder
+func listen() (deadlineListener, string, error) {
+	if path := getSocketPath(); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, "", fmt.Errorf("failed to make socket folder: %v", err)
+		}
+		if err := os.Chmod(filepath.Dir(path), 0700); err != nil {
+			return nil, "", fmt.Errorf("failed to set socket folder permissions: %v", err)
+		}
+		// Remove a stale socket, a running server would have answered the client
+		os.Remove(path)
+
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			listener.Close()
+			return nil, "", fmt.Errorf("failed to set socket permissions: %v", err)
+		}
+		return listener, "", nil
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Get used port and make a token
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		listener.Close()
+		return nil, "", fmt.Errorf("failed to make token: %v", err)
+	}
+	token := hex.EncodeToString(random)
+
+	// Save port and token
+	if err := saveServerAuth(port, token); err != nil {
+		listener.Close()
+		return nil, "", err
+	}
+	return listener, token, nil
+}
+
+// getSocketPath returns the path of the Unix socket, empty if $XDG_RUNTIME_DIR is not set
+func getSocketPath() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return ""
+	}
+	return filepath.Join(runtimeDir, "kpmenu", "kpmenu.sock")
+}
+
 func makeCacheFolder() error {
-	if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/"), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/"), 0700); err != nil {
 		return fmt.Errorf("failed to make cache folder: %v", err)
 	}
 	return nil
 }
 
-func savePort(port string) (err error) {
+func saveServerAuth(port string, token string) (err error) {
 	if err = makeCacheFolder(); err == nil {
-		if err = ioutil.WriteFile(
-			filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.port"),
-			[]byte(port),
-			0644,
-		); err != nil {
-			return fmt.Errorf("failed to make server port cache file: %v", err)
+		path := filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.auth")
+		// Remove the previous file, WriteFile doesn't change permissions of existing files
+		os.Remove(path)
+		if err = ioutil.WriteFile(path, []byte(port+"\n"+token), 0600); err != nil {
+			return fmt.Errorf("failed to make server auth cache file: %v", err)
 		}
 	}
 	return err
 }
 
-func getPort() (string, error) {
-	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.port"))
-	return string(data), err
+func getServerAuth() (port string, token string, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.auth"))
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(string(data), "\n", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid server auth cache file")
+	}
+	return parts[0], parts[1], nil
 }
@@ -0,0 +1,316 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// startTestServer serves the client packets with handlePacket until a quit request.
+// It listens on the Unix socket if $XDG_RUNTIME_DIR is set, otherwise on a loopback port saved into $HOME
+func startTestServer(t *testing.T, handlePacket func(Packet) (Reply, bool)) {
+	m := NewMenu()
+	m.Configuration.Flags.Daemon = true
+	done := make(chan error, 1)
+	go func() {
+		done <- setupListener(m, func(packet Packet) (Reply, bool) {
+			if packet.Request == RequestQuit {
+				return Reply{ExitCode: ExitOK}, true
+			}
+			return handlePacket(packet)
+		})
+	}()
+	t.Cleanup(func() {
+		if _, err := sendPacket(Packet{Request: RequestQuit}); err != nil {
+			t.Errorf("failed to stop the server: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("setupListener() failed: %v", err)
+		}
+	})
+
+	// Wait for the listener
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if path := getSocketPath(); path != "" {
+			_, err = os.Stat(path)
+		} else {
+			_, _, err = getServerAuth()
+		}
+		if err == nil {
+			return
+		}
+	}
+	t.Fatal("the server is not listening")
+}
+
+// echoPacket replies with the input of the packet
+func echoPacket(packet Packet) (Reply, bool) {
+	return Reply{ExitCode: ExitOK, Value: packet.Input}, false
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	startTestServer(t, echoPacket)
+
+	reply, err := sendPacket(Packet{Input: "hello"})
+	if err != nil || reply.Value != "hello" {
+		t.Fatalf("sendPacket() = %+v, %v, want hello", reply, err)
+	}
+
+	// Only the user can connect to the socket
+	info, err := os.Stat(getSocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions %o, want 600", perm)
+	}
+	if info, err := os.Stat(filepath.Dir(getSocketPath())); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("socket folder %v, %v, want permissions 700", info.Mode(), err)
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.auth")); !os.IsNotExist(err) {
+		t.Errorf("server.auth saved with a Unix socket: %v", err)
+	}
+}
+
+func TestServerLoopback(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("HOME", t.TempDir())
+	called := 0
+	startTestServer(t, func(packet Packet) (Reply, bool) {
+		called++
+		return echoPacket(packet)
+	})
+
+	// The client reads the port and the token from server.auth
+	reply, err := sendPacket(Packet{Input: "hello"})
+	if err != nil || reply.Value != "hello" {
+		t.Fatalf("sendPacket() = %+v, %v, want hello", reply, err)
+	}
+	info, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/server.auth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("server.auth permissions %o, want 600", perm)
+	}
+
+	// Packets with a wrong token are refused without a reply
+	port, token, err := getServerAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wrong := range []string{"", "wrong", token[:len(token)-1]} {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gob.NewEncoder(conn).Encode(Packet{Input: "hello", Token: wrong}); err != nil {
+			t.Fatal(err)
+		}
+		var reply Reply
+		if err := gob.NewDecoder(conn).Decode(&reply); err == nil {
+			t.Errorf("token %q got the reply %+v, want the connection closed", wrong, reply)
+		}
+		conn.Close()
+	}
+	if called != 1 {
+		t.Errorf("handled %d packets, want 1", called)
+	}
+}
+
+func TestCheckPeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := checkPeer(conn); err != nil {
+		t.Errorf("checkPeer() of the same user failed: %v", err)
+	}
+}
+
+func TestPacketGob(t *testing.T) {
+	packet := Packet{Request: RequestStatus, CliArguments: []string{"get", "--uuid", "abc"}, Input: "input", Token: "token"}
+	reply := Reply{ExitCode: ExitLocked, Message: "message", Error: "error", Value: "value",
+		Status: &ServerStatus{Locked: true, Database: "test.kdbx", CacheRemaining: -1}}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(packet); err != nil {
+		t.Fatal(err)
+	}
+	var decodedPacket Packet
+	if err := gob.NewDecoder(&buffer).Decode(&decodedPacket); err != nil || !reflect.DeepEqual(decodedPacket, packet) {
+		t.Errorf("decoded packet %+v, %v, want %+v", decodedPacket, err, packet)
+	}
+
+	buffer.Reset()
+	if err := gob.NewEncoder(&buffer).Encode(reply); err != nil {
+		t.Fatal(err)
+	}
+	var decodedReply Reply
+	if err := gob.NewDecoder(&buffer).Decode(&decodedReply); err != nil || !reflect.DeepEqual(decodedReply, reply) {
+		t.Errorf("decoded reply %+v, %v, want %+v", decodedReply, err, reply)
+	}
+}
+
+func TestHandleRequestExitCodes(t *testing.T) {
+	cfg := newTestConfiguration(t)
+	cfg.Database.Password = "password"
+	newLoadedMenu := func() *Menu {
+		m := NewMenu()
+		m.Configuration = cfg
+		m.Configuration.Flags.Daemon = true
+		m.Database = newTestDatabase(newTestEntry("Title", "Mail"))
+		m.Database.AddCredentialsToDatabase(cfg, cfg.Database.Password)
+		return m
+	}
+	writeTestDatabase(t, cfg, newLoadedMenu().Database)
+
+	tests := []struct {
+		name     string
+		loaded   bool
+		request  RequestType
+		wantCode int
+		wantExit bool
+	}{
+		{"status unlocked", true, RequestStatus, ExitOK, false},
+		{"status locked", false, RequestStatus, ExitLocked, false},
+		{"lock unlocked", true, RequestLock, ExitOK, false},
+		{"lock locked", false, RequestLock, ExitOK, false},
+		{"reload unlocked", true, RequestReload, ExitOK, false},
+		{"reload locked", false, RequestReload, ExitLocked, false},
+		{"quit unlocked", true, RequestQuit, ExitOK, true},
+		{"quit locked", false, RequestQuit, ExitOK, true},
+	}
+	for _, tt := range tests {
+		m := newLoadedMenu()
+		if !tt.loaded {
+			m.lockDatabase()
+		}
+		reply, exit := handleRequest(m, Packet{Request: tt.request})
+		if reply.ExitCode != tt.wantCode || exit != tt.wantExit {
+			t.Errorf("%s: handleRequest() = %d, %v, want %d, %v (%s)", tt.name, reply.ExitCode, exit, tt.wantCode, tt.wantExit, reply.Error)
+		}
+		if tt.request == RequestLock && m.Database.Loaded {
+			t.Errorf("%s: the database is still loaded", tt.name)
+		}
+		if tt.request == RequestStatus && (reply.Status == nil || reply.Status.Locked == tt.loaded) {
+			t.Errorf("%s: status %+v, want locked %v", tt.name, reply.Status, !tt.loaded)
+		}
+	}
+
+	// A failed reload
+	m := newLoadedMenu()
+	m.Configuration = NewConfiguration()
+	m.Configuration.Flags.Daemon = true
+	m.Configuration.Database.Database = cfg.Database.Database + ".missing"
+	if reply, exit := handleRequest(m, Packet{Request: RequestReload}); reply.ExitCode != ExitError || exit {
+		t.Errorf("failed reload: handleRequest() = %d, %v, want %d, false", reply.ExitCode, exit, ExitError)
+	}
+}
+
+func TestStartClientExitCodes(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	client := NewMenu()
+	client.Configuration.Flags.Status = true
+
+	// The server is not running
+	if code, err := StartClient(client); code != ExitNotRunning || err != ErrNotRunning {
+		t.Errorf("StartClient() without server = %d, %v, want %d, %v", code, err, ExitNotRunning, ErrNotRunning)
+	}
+
+	server := NewMenu()
+	server.Configuration.Flags.Daemon = true
+	startTestServer(t, func(packet Packet) (Reply, bool) {
+		return handleRequest(server, packet)
+	})
+	if code, err := StartClient(client); code != ExitLocked || err != nil {
+		t.Errorf("StartClient() with locked server = %d, %v, want %d", code, err, ExitLocked)
+	}
+	server.Database = newTestDatabase()
+	if code, err := StartClient(client); code != ExitOK || err != nil {
+		t.Errorf("StartClient() with unlocked server = %d, %v, want %d", code, err, ExitOK)
+	}
+}
+
+func TestHandleRequestReply(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	commandLine := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("kpmenu", flag.ContinueOnError)
+	t.Cleanup(func() { flag.CommandLine = commandLine })
+
+	m := NewMenu()
+	m.Configuration.InitializeFlags()
+	m.Configuration.Flags.Daemon = true
+	m.Configuration.General.Menu = PromptCustom
+	m.Configuration.General.ClipboardTool = ClipboardToolStdout
+	m.Configuration.Database.Database = "test.kdbx"
+	m.Database = newTestDatabase(newTestEntry("Title", "Mail", "UserName", "alice", "Password", "secret"))
+
+	tests := []struct {
+		name      string
+		arguments []string
+		answers   []string
+		wantCode  int
+		wantValue string
+		wantError string
+	}{
+		{"copied by stdout", nil, []string{"Show entries", "Mail - alice", "Password"}, ExitOK, "secret", ""},
+		{"get", []string{"get", "Mail", "UserName"}, nil, ExitOK, "alice", ""},
+		{"cancelled", nil, nil, ExitError, "", ""},
+		{"entry not found", []string{"get", "Bank"}, nil, ExitError, "", "no entry found"},
+		{"field not found", []string{"get", "Mail", "URL"}, nil, ExitError, "", "field URL not found in entry G/Mail"},
+		{"unknown command", []string{"unknown"}, nil, ExitError, "", "unknown command unknown"},
+	}
+	for _, tt := range tests {
+		m.Prompter = &fakePrompter{answers: tt.answers}
+		reply, exit := handleRequest(m, Packet{CliArguments: tt.arguments})
+		if reply.ExitCode != tt.wantCode || reply.Value != tt.wantValue || reply.Error != tt.wantError || exit {
+			t.Errorf("%s: handleRequest() = %+v, %v, want exit code %d, value %q and error %q", tt.name, reply, exit, tt.wantCode, tt.wantValue, tt.wantError)
+		}
+	}
+	if m.Output != os.Stdout {
+		t.Error("the output is not restored")
+	}
+}
+
+func TestStartClientReply(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	reply := Reply{ExitCode: ExitError, Value: "value", Error: "error"}
+	startTestServer(t, func(packet Packet) (Reply, bool) {
+		return reply, false
+	})
+
+	// The exit code and the value reach the client
+	var output bytes.Buffer
+	client := NewMenu()
+	client.Output = &output
+	if code, err := StartClient(client); code != ExitError || err != nil {
+		t.Errorf("StartClient() = %d, %v, want %d", code, err, ExitError)
+	}
+	if output.String() != "value\n" {
+		t.Errorf("StartClient() printed %q, want the value", output.String())
+	}
+}
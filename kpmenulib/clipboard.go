@@ -3,6 +3,7 @@ package kpmenulib
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"log"
 	"os/exec"
 	"strings"
@@ -20,6 +21,11 @@ func CopyToClipboard(menu *Menu, text string) error {
 		cmd = exec.Command("xsel", "-ib")
 	case ClipboardToolWlclipboard:
 		cmd = exec.Command("wl-copy")
+	case ClipboardToolStdout:
+		_, err := fmt.Fprintln(menu.Output, text)
+		return err
+	case ClipboardToolOSC52:
+		return writeOSC52(text)
 	case ClipboardToolCustom:
 		customCommand, err := shlex.Split(menu.Configuration.Executable.CustomClipboardCopy)
 		if err != nil {
@@ -54,6 +60,8 @@ func GetClipboard(menu *Menu) (string, error) {
 		cmd = exec.Command("xsel", "-b")
 	case ClipboardToolWlclipboard:
 		cmd = exec.Command("wl-paste", "-n")
+	case ClipboardToolStdout, ClipboardToolOSC52:
+		return "", fmt.Errorf("%s can't be read", menu.Configuration.General.ClipboardTool)
 	case ClipboardToolCustom:
 		customCommand, err := shlex.Split(menu.Configuration.Executable.CustomClipboardPaste)
 		if err != nil {
@@ -79,6 +87,16 @@ func GetClipboard(menu *Menu) (string, error) {
 
 // CleanClipboard cleans the clipboard, if not changed
 func CleanClipboard(menu *Menu, text string) {
+	switch menu.Configuration.General.ClipboardTool {
+	case ClipboardToolStdout:
+		// Nothing to clean
+		return
+	case ClipboardToolOSC52:
+		// The terminal clipboard can't be read, clean it anyway
+		cleanOSC52(menu)
+		return
+	}
+
 	if menu.Configuration.General.ClipboardTimeout > 0 {
 		// Goroutine
 		// Its async so any error will be printed
@@ -131,3 +149,19 @@ func CleanClipboard(menu *Menu, text string) {
 		}()
 	}
 }
+
+func cleanOSC52(menu *Menu) {
+	if menu.Configuration.General.ClipboardTimeout > 0 {
+		menu.WaitGroup.Add(1)
+		go func() {
+			defer menu.WaitGroup.Done()
+			time.Sleep(time.Duration(menu.Configuration.General.ClipboardTimeout) * time.Second)
+
+			if err := writeOSC52(""); err != nil {
+				log.Printf("failed to clean '%s' clipboard: %s", menu.Configuration.General.ClipboardTool, err)
+			} else {
+				log.Printf("cleaned clipboard")
+			}
+		}()
+	}
+}
@@ -16,18 +16,30 @@ type Configuration struct {
 	Executable ConfigurationExecutable
 	Style      ConfigurationStyle
 	Database   ConfigurationDatabase
+	Generator  ConfigurationGenerator
 	Flags      Flags
 }
 
 // ConfigurationGeneral is the sub-structure of the configuration related to general kpmenu settings
 type ConfigurationGeneral struct {
-	Menu             string // Which menu to use
-	ClipboardTool    string // Clipboard tool to use
-	ClipboardTimeout int    // Clipboard timeout before clean it
-	NoCache          bool   // Flag to do not cache master password
-	CacheOneTime     bool   // Cache the password only the first time you write it
-	CacheTimeout     int    // Timeout of cache
-	NoOTP            bool   // Flag to do not handle OTPs
+	Menu               string // Which menu to use
+	ClipboardTool      string // Clipboard tool to use
+	ClipboardTimeout   int    // Clipboard timeout before clean it
+	NoCache            bool   // Flag to do not cache master password
+	CacheOneTime       bool   // Cache the password only the first time you write it
+	CacheTimeout       int    // Timeout of cache
+	NoOTP              bool   // Flag to do not handle OTPs
+	OTPThreshold       int    // Seconds before the OTP expires to use the next one
+	OTPWait            bool   // Wait for the next OTP instead of using it in advance
+	Autotype           bool   // Type selected fields instead of copying them
+	AutotypeTool       string // Autotype tool to use
+	WindowMatch        bool   // List first the entries matching the active window
+	WindowMatchSkip    bool   // Skip entry selection when only one entry matches the active window
+	BrowseGroups       bool   // Browse the groups at entry selection instead of listing every entry
+	SSHAgent           bool   // Serve SSH keys of the database as ssh-agent
+	SSHAgentSocket     string // Path of the ssh-agent socket
+	SecretService      bool   // Expose the database as freedesktop Secret Service
+	SecretServiceGroup string // Path of the group exposed as Secret Service collection
 }
 
 // ConfigurationExecutable is the sub-structure of the configuration related to tools executed by kpmenu
@@ -39,6 +51,9 @@ type ConfigurationExecutable struct {
 	CustomClipboardCopy  string // Custom executable for clipboard copy
 	CustomClipboardPaste string // Custom executable for clipboard paste
 	CustomClipboardClean string // Custom executable for clipboard clean
+	CustomAutotypeText   string // Custom executable for autotype text, given as stdin
+	CustomAutotypeKey    string // Custom executable for autotype key press, given as last argument
+	ActiveWindowTitle    string // Executable that prints the title of the active window
 }
 
 // ConfigurationStyle is the sub-structure of the configuration related to style of dmenu
@@ -48,6 +63,8 @@ type ConfigurationStyle struct {
 	TextMenu           string
 	TextEntry          string
 	TextField          string
+	TextGroup          string
+	TextPolicy         string
 	FormatEntry        string
 	ArgsPassword       string
 	ArgsMenu           string
@@ -65,17 +82,53 @@ type ConfigurationDatabase struct {
 	FillBlacklist   string
 }
 
+// ConfigurationGenerator is the sub-structure of the configuration related to the password generator
+type ConfigurationGenerator struct {
+	Policy   string                     // Default policy
+	Policies map[string]GeneratorPolicy // Policies by name
+}
+
 // Flags is the sub-structure of the configuration used to handle flags that aren't into the config file
 type Flags struct {
-	Daemon  bool
-	Version bool
+	Daemon      bool
+	Version     bool
+	Lock        bool     // Request to lock the database
+	Status      bool     // Request the status of the server
+	Reload      bool     // Request to reload the database
+	Quit        bool     // Request to stop the server
+	Command     string   // Command given as first argument
+	CommandArgs []string // Arguments of the command
+	UUID        string   // UUID of the entry, for the get command
+	URL         string   // URL of the entry, for the get command
+	Field       string   // Field of the entry, for the get command
+	OTP         bool     // Get the OTP of the entry, for the get command
+	JSON        bool     // Print entries as JSON, for the list and search commands
+	Secrets     bool     // Print secret values of entries, for the list and search commands
+	Group       string   // Group of entries, for the list and search commands
+	Tag         string   // Tag of entries, for the list and search commands
+	Expired     bool     // Only expired entries, for the list and search commands
 }
 
+// Commands given as first argument
+const (
+	CommandGenerate      = "generate"
+	CommandGet           = "get"
+	CommandList          = "list"
+	CommandSearch        = "search"
+	CommandGitCredential = "git-credential"
+	CommandBrowserHost   = "browser-host"
+)
+
 // Menu tools used for prompts
 const (
 	PromptDmenu  = "dmenu"
 	PromptRofi   = "rofi"
 	PromptWofi   = "wofi"
+	PromptBemenu = "bemenu"
+	PromptFuzzel = "fuzzel"
+	PromptTofi   = "tofi"
+	PromptFzf    = "fzf"
+	PromptTty    = "tty"
 	PromptCustom = "custom"
 )
 
@@ -83,9 +136,19 @@ const (
 const (
 	ClipboardToolXsel        = "xsel"
 	ClipboardToolWlclipboard = "wl-clipboard"
+	ClipboardToolStdout      = "stdout"
+	ClipboardToolOSC52       = "osc52"
 	ClipboardToolCustom      = "custom"
 )
 
+// Autotype tools used to type into the focused window
+const (
+	AutotypeToolXdotool = "xdotool"
+	AutotypeToolWtype   = "wtype"
+	AutotypeToolYdotool = "ydotool"
+	AutotypeToolCustom  = "custom"
+)
+
 // NewConfiguration initializes a new Configuration pointer
 func NewConfiguration() *Configuration {
 	return &Configuration{
@@ -94,6 +157,11 @@ func NewConfiguration() *Configuration {
 			ClipboardTool:    ClipboardToolXsel,
 			ClipboardTimeout: 15,
 			CacheTimeout:     60,
+			OTPThreshold:     5,
+			AutotypeTool:     AutotypeToolXdotool,
+		},
+		Executable: ConfigurationExecutable{
+			ActiveWindowTitle: "xdotool getactivewindow getwindowname",
 		},
 		Style: ConfigurationStyle{
 			PasswordBackground: "black",
@@ -101,12 +169,30 @@ func NewConfiguration() *Configuration {
 			TextMenu:           "Select",
 			TextEntry:          "Entry",
 			TextField:          "Field",
+			TextGroup:          "Group",
+			TextPolicy:         "Policy",
 			FormatEntry:        "{Title} - {UserName}",
 		},
 		Database: ConfigurationDatabase{
 			FieldOrder:      "Password UserName URL",
 			FillOtherFields: true,
 		},
+		Generator: ConfigurationGenerator{
+			Policy: "default",
+			Policies: map[string]GeneratorPolicy{
+				"default": {
+					Length:    20,
+					Lowercase: true,
+					Uppercase: true,
+					Digits:    true,
+					Symbols:   true,
+				},
+				"passphrase": {
+					Words:     6,
+					Separator: "-",
+				},
+			},
+		},
 	}
 }
 
@@ -138,15 +224,40 @@ func (c *Configuration) LoadConfig() error {
 		if err := viper.UnmarshalKey("executable", &c.Executable); err != nil {
 			return NewErrorParseConfiguration("failed to parse config file (executable): %v", err)
 		}
+
+		// Unmarshal generator
+		if err := viper.UnmarshalKey("generator", &c.Generator); err != nil {
+			return NewErrorParseConfiguration("failed to parse config file (generator): %v", err)
+		}
 	}
 	return nil
 }
 
+// TerminalBound checks if the menu or the clipboard tool use the terminal of kpmenu,
+// a daemon started by another terminal can't use them
+func (c *Configuration) TerminalBound() bool {
+	return c.General.Menu == PromptTty || c.General.Menu == PromptFzf ||
+		c.General.ClipboardTool == ClipboardToolOSC52
+}
+
 // InitializeFlags prepare cli flags
 func (c *Configuration) InitializeFlags() {
 	// Flags
 	flag.BoolVar(&c.Flags.Daemon, "daemon", false, "Start kpmenu directly as daemon")
 	flag.BoolVarP(&c.Flags.Version, "version", "v", false, "Show kpmenu version")
+	flag.BoolVar(&c.Flags.Lock, "lock", false, "Lock the database of the running kpmenu")
+	flag.BoolVar(&c.Flags.Status, "status", false, "Show the status of the running kpmenu")
+	flag.BoolVar(&c.Flags.Reload, "reload", false, "Reload the database of the running kpmenu")
+	flag.BoolVar(&c.Flags.Quit, "quit", false, "Stop the running kpmenu")
+	flag.StringVar(&c.Flags.UUID, "uuid", "", "UUID of the entry to get, hex or base64 encoded")
+	flag.StringVar(&c.Flags.URL, "url", "", "URL of the entry to get, matched by host")
+	flag.StringVar(&c.Flags.Field, "field", "", "Field of the entry to get (default \"Password\")")
+	flag.BoolVar(&c.Flags.OTP, "otp", false, "Get the OTP of the entry")
+	flag.BoolVar(&c.Flags.JSON, "json", false, "List entries as JSON")
+	flag.BoolVar(&c.Flags.Secrets, "secrets", false, "List entries with their secret values")
+	flag.StringVar(&c.Flags.Group, "group", "", "List entries of the group and its subgroups")
+	flag.StringVar(&c.Flags.Tag, "tag", "", "List entries with the tag")
+	flag.BoolVar(&c.Flags.Expired, "expired", false, "List only expired entries")
 
 	// General
 	flag.StringVarP(&c.General.Menu, "menu", "m", c.General.Menu, "Choose which menu to use")
@@ -156,6 +267,17 @@ func (c *Configuration) InitializeFlags() {
 	flag.BoolVar(&c.General.CacheOneTime, "cacheOneTime", c.General.CacheOneTime, "Cache the database only the first time")
 	flag.IntVar(&c.General.CacheTimeout, "cacheTimeout", c.General.CacheTimeout, "Timeout of cache in seconds")
 	flag.BoolVar(&c.General.NoOTP, "nootp", c.General.NoOTP, "Disable OTP handling")
+	flag.IntVar(&c.General.OTPThreshold, "otpThreshold", c.General.OTPThreshold, "Use the next OTP if the current one expires within these seconds (0 = disabled)")
+	flag.BoolVar(&c.General.OTPWait, "otpWait", c.General.OTPWait, "Wait for the next OTP instead of using it in advance")
+	flag.BoolVarP(&c.General.Autotype, "autotype", "a", c.General.Autotype, "Type the selected field into the focused window instead of copying it")
+	flag.StringVar(&c.General.AutotypeTool, "autotypeTool", c.General.AutotypeTool, "Choose which autotype tool to use")
+	flag.BoolVarP(&c.General.WindowMatch, "windowMatch", "w", c.General.WindowMatch, "List first the entries matching the active window")
+	flag.BoolVar(&c.General.WindowMatchSkip, "windowMatchSkip", c.General.WindowMatchSkip, "Skip entry selection when only one entry matches the active window")
+	flag.BoolVarP(&c.General.BrowseGroups, "browseGroups", "g", c.General.BrowseGroups, "Browse the groups at entry selection instead of listing every entry")
+	flag.BoolVar(&c.General.SSHAgent, "sshAgent", c.General.SSHAgent, "Serve the SSH keys attached to entries as ssh-agent while the database is cached")
+	flag.StringVar(&c.General.SSHAgentSocket, "sshAgentSocket", c.General.SSHAgentSocket, "Path of the ssh-agent socket (default $XDG_RUNTIME_DIR/kpmenu/ssh-agent.sock)")
+	flag.BoolVar(&c.General.SecretService, "secretService", c.General.SecretService, "Expose the database as freedesktop Secret Service on the session bus while it is cached")
+	flag.StringVar(&c.General.SecretServiceGroup, "secretServiceGroup", c.General.SecretServiceGroup, "Path of the group exposed as Secret Service collection, required by --secretService")
 
 	// Executable
 	flag.StringVar(&c.Executable.CustomPromptPassword, "customPromptPassword", c.Executable.CustomPromptPassword, "Custom executable for prompt password")
@@ -165,6 +287,9 @@ func (c *Configuration) InitializeFlags() {
 	flag.StringVar(&c.Executable.CustomClipboardCopy, "customClipboardCopy", c.Executable.CustomClipboardCopy, "Custom executable for clipboard copy")
 	flag.StringVar(&c.Executable.CustomClipboardPaste, "customClipboardPaste", c.Executable.CustomClipboardPaste, "Custom executable for clipboard paste")
 	flag.StringVar(&c.Executable.CustomClipboardClean, "customClipboardClean", c.Executable.CustomClipboardClean, "Custom executable for clipboard clean")
+	flag.StringVar(&c.Executable.CustomAutotypeText, "customAutotypeText", c.Executable.CustomAutotypeText, "Custom executable for autotype text, given as stdin")
+	flag.StringVar(&c.Executable.CustomAutotypeKey, "customAutotypeKey", c.Executable.CustomAutotypeKey, "Custom executable for autotype key press, given as last argument")
+	flag.StringVar(&c.Executable.ActiveWindowTitle, "activeWindowTitle", c.Executable.ActiveWindowTitle, "Executable that prints the title of the active window")
 
 	// Style
 	flag.StringVar(&c.Style.PasswordBackground, "passwordBackground", c.Style.PasswordBackground, "Color of dmenu background and text for password selection, used to hide password typing")
@@ -172,6 +297,8 @@ func (c *Configuration) InitializeFlags() {
 	flag.StringVar(&c.Style.TextMenu, "textMenu", c.Style.TextMenu, "Label for menu selection")
 	flag.StringVar(&c.Style.TextEntry, "textEntry", c.Style.TextEntry, "Label for entry selection")
 	flag.StringVar(&c.Style.TextField, "textField", c.Style.TextField, "Label for field selection")
+	flag.StringVar(&c.Style.TextGroup, "textGroup", c.Style.TextGroup, "Label for group selection")
+	flag.StringVar(&c.Style.TextPolicy, "textPolicy", c.Style.TextPolicy, "Label for generator policy selection")
 	flag.StringVar(&c.Style.ArgsPassword, "argsPassword", c.Style.ArgsPassword, "Additional arguments for dmenu at password selection, separated by a space")
 	flag.StringVar(&c.Style.ArgsMenu, "argsMenu", c.Style.ArgsMenu, "Additional arguments for dmenu at menu selection, separated by a space")
 	flag.StringVar(&c.Style.ArgsEntry, "argsEntry", c.Style.ArgsEntry, "Additional arguments for dmenu at entry selection, separated by a space")
@@ -184,11 +311,24 @@ func (c *Configuration) InitializeFlags() {
 	flag.StringVar(&c.Database.FieldOrder, "fieldOrder", c.Database.FieldOrder, "String order of fields to show on field selection")
 	flag.BoolVar(&c.Database.FillOtherFields, "fillOtherFields", c.Database.FillOtherFields, "Enable fill of remaining fields")
 	flag.StringVar(&c.Database.FillBlacklist, "fillBlacklist", c.Database.FillBlacklist, "String of blacklisted fields that won't be shown")
+
+	// Generator
+	flag.StringVar(&c.Generator.Policy, "policy", c.Generator.Policy, "Policy used to generate passwords")
 }
 
 // ParseFlags parses cli flags with given arguments
 func (c *Configuration) ParseFlags(args []string) {
+	// Reset flags of commands, they could be set by a previous client call
+	c.Flags.UUID, c.Flags.URL, c.Flags.Field, c.Flags.OTP = "", "", "", false
+	c.Flags.JSON, c.Flags.Secrets, c.Flags.Group, c.Flags.Tag, c.Flags.Expired = false, false, "", "", false
 	flag.CommandLine.Parse(args)
+
+	// Get command and its arguments
+	c.Flags.Command, c.Flags.CommandArgs = "", nil
+	if flag.NArg() > 0 {
+		c.Flags.Command = flag.Arg(0)
+		c.Flags.CommandArgs = flag.Args()[1:]
+	}
 }
 
 // ErrParseConfiguration is the error return if the configuration loading fails
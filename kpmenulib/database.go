@@ -1,10 +1,17 @@
 package kpmenulib
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
 )
 
 // Database contains the KeePass database and its entry list
@@ -12,12 +19,25 @@ type Database struct {
 	Loaded  bool
 	Keepass *gokeepasslib.Database
 	Entries []Entry
+
+	fileInfo os.FileInfo // Database file as opened or last saved, to not overwrite changes made by others
 }
 
+// ErrDatabaseChanged is returned on save if the database file changed since it was opened
+var ErrDatabaseChanged = errors.New("the database file changed since it was opened, reload it to save changes")
+
 // Entry is a container for keepass entry
 type Entry struct {
 	UUID      gokeepasslib.UUID
 	FullEntry gokeepasslib.Entry
+	Group     string // Name of the group containing the entry
+	Path      string // Path of the group containing the entry, groups separated by /
+}
+
+// GroupItem is a group of the database, pointing into the KeePass tree
+type GroupItem struct {
+	Path  string // Path of the group, groups separated by /
+	Group *gokeepasslib.Group
 }
 
 // NewDatabase initializes the Database struct
@@ -50,39 +70,270 @@ func (db *Database) AddCredentialsToDatabase(cfg *Configuration, password string
 func (db *Database) OpenDatabase(cfg *Configuration) error {
 	// Open database file
 	file, err := os.Open(cfg.Database.Database)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
 	if err == nil {
 		err = gokeepasslib.NewDecoder(file).Decode(db.Keepass)
-		if err == nil {
-			err = db.Keepass.UnlockProtectedEntries()
+	}
+	if err == nil {
+		err = db.Keepass.UnlockProtectedEntries()
+	}
+	if err == nil {
+		db.fileInfo = info
+	}
+	return err
+}
+
+// SaveDatabase encodes the database and replaces the database file atomically,
+// the previous file is kept as backup with the .bak extension.
+// ErrDatabaseChanged is returned if the file was modified since it was opened, as by KeePassXC or a sync
+func (db *Database) SaveDatabase(cfg *Configuration) error {
+	// Follow symlinks, to replace the real file
+	path, err := filepath.EvalSymlinks(cfg.Database.Database)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if db.fileInfo != nil && (!info.ModTime().Equal(db.fileInfo.ModTime()) || info.Size() != db.fileInfo.Size()) {
+		return ErrDatabaseChanged
+	}
+
+	// Write into a temporary file of the same folder, removed if not renamed
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to make temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Protected values must be locked before encoding, then unlocked again to be used.
+	// They are locked with the new inner stream key
+	err = db.newFileSeeds()
+	if err == nil {
+		err = db.Keepass.LockProtectedEntries()
+	}
+	if err == nil {
+		err = gokeepasslib.NewEncoder(tmp).Encode(db.Keepass)
+		if errUnlock := db.Keepass.UnlockProtectedEntries(); err == nil {
+			err = errUnlock
 		}
 	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode database: %v", err)
+	}
+
+	// Backup the previous file
+	if err := copyFile(path, path+".bak", info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to backup database: %v", err)
+	}
+
+	// Replace the database file
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace database: %v", err)
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	if info, err := os.Stat(path); err == nil {
+		db.fileInfo = info
+	}
+	log.Printf("database saved")
+	return nil
+}
+
+// newFileSeeds replaces the seeds, the IV and the inner stream key of the database with random values
+// of the same length, as KeePass does on every save, so that two versions of the file never share them
+func (db *Database) newFileSeeds() error {
+	fh := db.Keepass.Header.FileHeaders
+	seeds := [][]byte{fh.MasterSeed, fh.EncryptionIV, fh.TransformSeed, fh.ProtectedStreamKey, fh.StreamStartBytes}
+	if fh.KdfParameters != nil {
+		seeds = append(seeds, fh.KdfParameters.Salt[:])
+	}
+	if ih := db.Keepass.Content.InnerHeader; ih != nil {
+		seeds = append(seeds, ih.InnerRandomStreamKey)
+	}
+	for _, seed := range seeds {
+		if _, err := rand.Read(seed); err != nil {
+			return fmt.Errorf("failed to generate seeds: %v", err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies src into dst, syncing it to the disk
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
 	return err
 }
 
+// Groups returns every group of the database, the root group has an empty path
+func (db *Database) Groups() []GroupItem {
+	var groups []GroupItem
+	root := db.Keepass.Content.Root
+	if len(root.Groups) == 1 {
+		groups = append(groups, GroupItem{Path: "", Group: &root.Groups[0]})
+		return append(groups, iterateGroupItems(root.Groups[0].Groups, "")...)
+	}
+	return iterateGroupItems(root.Groups, "")
+}
+
+func iterateGroupItems(kpGroups []gokeepasslib.Group, parentPath string) []GroupItem {
+	var groups []GroupItem
+	for i := range kpGroups {
+		path := kpGroups[i].Name
+		if parentPath != "" {
+			path = parentPath + "/" + kpGroups[i].Name
+		}
+		groups = append(groups, GroupItem{Path: path, Group: &kpGroups[i]})
+		groups = append(groups, iterateGroupItems(kpGroups[i].Groups, path)...)
+	}
+	return groups
+}
+
+// AddEntry adds the entry into the group and updates the list of entries
+func (db *Database) AddEntry(group *gokeepasslib.Group, entry gokeepasslib.Entry) {
+	group.Entries = append(group.Entries, entry)
+	db.IterateDatabase()
+}
+
+// UpdateEntry replaces the entry with the same UUID into the KeePass tree and updates the list of entries
+// Returns the replaced entry
+func (db *Database) UpdateEntry(entry gokeepasslib.Entry) (gokeepasslib.Entry, error) {
+	kpEntry := findEntry(db.Keepass.Content.Root.Groups, entry.UUID)
+	if kpEntry == nil {
+		return gokeepasslib.Entry{}, errors.New("entry not found into the database")
+	}
+	previous := *kpEntry
+	*kpEntry = entry
+	db.IterateDatabase()
+	return previous, nil
+}
+
+// findEntry returns a pointer to the entry into the KeePass tree
+func findEntry(kpGroups []gokeepasslib.Group, uuid gokeepasslib.UUID) *gokeepasslib.Entry {
+	for i := range kpGroups {
+		for j := range kpGroups[i].Entries {
+			if kpGroups[i].Entries[j].UUID.Compare(uuid) {
+				return &kpGroups[i].Entries[j]
+			}
+		}
+		if e := findEntry(kpGroups[i].Groups, uuid); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// PushHistory pushes the previous version of the entry into its history, as KeePass does,
+// and updates its last modification time. The oldest versions are removed over the history limit of the database
+func (db *Database) PushHistory(entry *gokeepasslib.Entry, previous gokeepasslib.Entry) {
+	// The history versions have no history
+	previous.Histories = nil
+
+	var versions []gokeepasslib.Entry
+	if len(entry.Histories) > 0 {
+		versions = append(versions, entry.Histories[0].Entries...)
+	}
+	versions = append(versions, previous)
+	if max := db.Keepass.Content.Meta.HistoryMaxItems; max >= 0 && int64(len(versions)) > max {
+		versions = versions[int64(len(versions))-max:]
+	}
+	entry.Histories = []gokeepasslib.History{{Entries: versions}}
+
+	now := w.Now()
+	entry.Times.LastModificationTime = &now
+}
+
+// SetEntryValue sets the value of the entry field, adding it if missing
+func SetEntryValue(entry *gokeepasslib.Entry, key string, value string, protected bool) {
+	if vd := entry.Get(key); vd != nil {
+		vd.Value.Content = value
+		return
+	}
+	entry.Values = append(entry.Values, gokeepasslib.ValueData{
+		Key:   key,
+		Value: gokeepasslib.V{Content: value, Protected: w.NewBoolWrapper(protected)},
+	})
+}
+
 // IterateDatabase iterates the database and makes a list of entries
 func (db *Database) IterateDatabase() {
 	var entries []Entry
-	for _, sub := range db.Keepass.Content.Root.Groups {
-		entries = append(entries, iterateGroup(sub)...)
+	root := db.RootGroup()
+	for _, kpEntry := range root.Entries {
+		entries = append(entries, Entry{
+			UUID:      kpEntry.UUID,
+			FullEntry: kpEntry,
+		})
+	}
+	for _, sub := range root.Groups {
+		entries = append(entries, iterateGroup(sub, "")...)
 	}
 	db.Entries = entries
 }
 
-func iterateGroup(kpGroup gokeepasslib.Group) []Entry {
+// RootGroup returns the root group of the database, the path of its entries is empty.
+// If the database has more than one root group, a group containing all of them is returned
+func (db *Database) RootGroup() gokeepasslib.Group {
+	groups := db.Keepass.Content.Root.Groups
+	if len(groups) == 1 {
+		return groups[0]
+	}
+	return gokeepasslib.Group{Groups: groups}
+}
+
+func iterateGroup(kpGroup gokeepasslib.Group, parentPath string) []Entry {
 	var entries []Entry
+	path := kpGroup.Name
+	if parentPath != "" {
+		path = parentPath + "/" + kpGroup.Name
+	}
+
 	// Get entries of the current group
 	for _, kpEntry := range kpGroup.Entries {
 		// Insert entry
 		entries = append(entries, Entry{
 			UUID:      kpEntry.UUID,
 			FullEntry: kpEntry,
+			Group:     kpGroup.Name,
+			Path:      path,
 		})
-		//(*entries)[uuid] = Entry{FullEntry: kpEntry}
 	}
 
 	// Continue to iterate subgroups
 	for _, sub := range kpGroup.Groups {
-		entries = append(entries, iterateGroup(sub)...)
+		entries = append(entries, iterateGroup(sub, path)...)
 	}
 	return entries
 }
@@ -0,0 +1,257 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// newTestConfiguration makes a configuration with the database into a temporary folder
+func newTestConfiguration(t *testing.T) *Configuration {
+	cfg := NewConfiguration()
+	cfg.Database.Database = filepath.Join(t.TempDir(), "test.kdbx")
+	return cfg
+}
+
+// writeTestDatabase writes the database into the file of the configuration
+func writeTestDatabase(t *testing.T, cfg *Configuration, db *Database) {
+	file, err := os.Create(cfg.Database.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = db.Keepass.LockProtectedEntries()
+	if err == nil {
+		err = gokeepasslib.NewEncoder(file).Encode(db.Keepass)
+	}
+	if errUnlock := db.Keepass.UnlockProtectedEntries(); err == nil {
+		err = errUnlock
+	}
+	if err != nil {
+		t.Fatalf("failed to write the database: %v", err)
+	}
+}
+
+func TestSaveDatabase(t *testing.T) {
+	kdbx4 := func(db *gokeepasslib.Database) {
+		gokeepasslib.WithDatabaseKDBXVersion4()(db)
+		// Keep the Argon2 memory low to run fast
+		db.Header.FileHeaders.KdfParameters.Memory = 1024 * 1024
+	}
+	tests := []struct {
+		name    string
+		options []gokeepasslib.DatabaseOption
+	}{
+		{"KDBX 3.1", nil},
+		{"KDBX 4", []gokeepasslib.DatabaseOption{kdbx4}},
+	}
+	for _, tt := range tests {
+		cfg := newTestConfiguration(t)
+		db := newTestDatabase(
+			newTestEntry("Title", "Mail", "UserName", "alice", "Password", "first"),
+			newTestEntry("Title", "Bank", "UserName", "bob", "Password", "second"),
+		)
+		root := db.Keepass.Content.Root
+		db.Keepass = gokeepasslib.NewDatabase(tt.options...)
+		db.Keepass.Content.Root = root
+		db.AddCredentialsToDatabase(cfg, "password")
+		db.IterateDatabase()
+
+		// The file to replace
+		writeTestDatabase(t, cfg, db)
+
+		previous := *db.Keepass.Header.FileHeaders
+		previous.MasterSeed = append([]byte{}, previous.MasterSeed...)
+		previous.EncryptionIV = append([]byte{}, previous.EncryptionIV...)
+		if err := db.SaveDatabase(cfg); err != nil {
+			t.Fatalf("%s: SaveDatabase() failed: %v", tt.name, err)
+		}
+
+		// The saved file is opened with the same credentials
+		saved := NewDatabase()
+		saved.AddCredentialsToDatabase(cfg, "password")
+		if err := saved.OpenDatabase(cfg); err != nil {
+			t.Fatalf("%s: failed to open the saved database: %v", tt.name, err)
+		}
+		saved.IterateDatabase()
+		if len(saved.Entries) != len(db.Entries) {
+			t.Fatalf("%s: saved %d entries, want %d", tt.name, len(saved.Entries), len(db.Entries))
+		}
+		for i, e := range saved.Entries {
+			want := db.Entries[i].FullEntry
+			if e.FullEntry.GetTitle() != want.GetTitle() || e.FullEntry.GetPassword() != want.GetPassword() {
+				t.Errorf("%s: saved entry %q with password %q, want %q with password %q", tt.name,
+					e.FullEntry.GetTitle(), e.FullEntry.GetPassword(), want.GetTitle(), want.GetPassword())
+			}
+		}
+
+		fh := saved.Keepass.Header.FileHeaders
+		if bytes.Equal(fh.MasterSeed, previous.MasterSeed) {
+			t.Errorf("%s: the master seed did not change", tt.name)
+		}
+		if bytes.Equal(fh.EncryptionIV, previous.EncryptionIV) {
+			t.Errorf("%s: the encryption IV did not change", tt.name)
+		}
+		if len(fh.EncryptionIV) != len(previous.EncryptionIV) {
+			t.Errorf("%s: the encryption IV is %d bytes, want %d", tt.name, len(fh.EncryptionIV), len(previous.EncryptionIV))
+		}
+		if _, err := os.Stat(cfg.Database.Database + ".bak"); err != nil {
+			t.Errorf("%s: missing backup: %v", tt.name, err)
+		}
+	}
+}
+
+func TestSaveDatabaseChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Configuration)
+	}{
+		{"modified", func(cfg *Configuration) {
+			other := newTestDatabase(newTestEntry("Title", "Mail", "Password", "first"), newTestEntry("Title", "Added in KeePassXC"))
+			other.AddCredentialsToDatabase(cfg, "password")
+			writeTestDatabase(t, cfg, other)
+		}},
+		{"touched", func(cfg *Configuration) {
+			modified := time.Now().Add(time.Minute)
+			if err := os.Chtimes(cfg.Database.Database, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		cfg := newTestConfiguration(t)
+		db := newTestDatabase(newTestEntry("Title", "Mail", "Password", "first"))
+		db.AddCredentialsToDatabase(cfg, "password")
+		writeTestDatabase(t, cfg, db)
+
+		opened := NewDatabase()
+		opened.AddCredentialsToDatabase(cfg, "password")
+		if err := opened.OpenDatabase(cfg); err != nil {
+			t.Fatal(err)
+		}
+		opened.IterateDatabase()
+
+		// The file opened is saved more times
+		for i := 0; i < 2; i++ {
+			if err := opened.SaveDatabase(cfg); err != nil {
+				t.Fatalf("%s: SaveDatabase() of the opened file failed: %v", tt.name, err)
+			}
+		}
+
+		// The file changed by others is not overwritten
+		tt.change(cfg)
+		before, err := ioutil.ReadFile(cfg.Database.Database)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := opened.SaveDatabase(cfg); err != ErrDatabaseChanged {
+			t.Errorf("%s: SaveDatabase() = %v, want %v", tt.name, err, ErrDatabaseChanged)
+		}
+		if after, err := ioutil.ReadFile(cfg.Database.Database); err != nil || !bytes.Equal(after, before) {
+			t.Errorf("%s: the changed file was overwritten", tt.name)
+		}
+
+		// Saved again once reopened
+		if err := opened.OpenDatabase(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if err := opened.SaveDatabase(cfg); err != nil {
+			t.Errorf("%s: SaveDatabase() of the reopened file failed: %v", tt.name, err)
+		}
+	}
+}
+
+func TestPushHistory(t *testing.T) {
+	tests := []struct {
+		max  int64
+		want []string // Passwords of the history versions, oldest first
+	}{
+		{-1, []string{"v1", "v2", "v3", "v4"}},
+		{0, nil},
+		{2, []string{"v3", "v4"}},
+		{10, []string{"v1", "v2", "v3", "v4"}},
+	}
+	for _, tt := range tests {
+		db := newTestDatabase(newTestEntry("Title", "Mail", "Password", "v1"))
+		db.Keepass.Content.Meta.HistoryMaxItems = tt.max
+		entry := db.Entries[0].FullEntry
+		for _, password := range []string{"v2", "v3", "v4", "v5"} {
+			updated := entry
+			updated.Values = append([]gokeepasslib.ValueData{}, entry.Values...)
+			SetEntryValue(&updated, "Password", password, true)
+			db.PushHistory(&updated, entry)
+			if _, err := db.UpdateEntry(updated); err != nil {
+				t.Fatal(err)
+			}
+			entry = updated
+		}
+
+		e := testEntry(t, db, "Mail")
+		if got := e.FullEntry.GetPassword(); got != "v5" {
+			t.Errorf("max %d: password %q, want v5", tt.max, got)
+		}
+		var got []string
+		for _, h := range e.FullEntry.Histories {
+			for _, version := range h.Entries {
+				if len(version.Histories) > 0 {
+					t.Errorf("max %d: history version %s has a history", tt.max, version.GetPassword())
+				}
+				got = append(got, version.GetPassword())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("max %d: history %q, want %q", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateEntryFieldHistory(t *testing.T) {
+	m := NewMenu()
+	m.Configuration = newTestConfiguration(t)
+	m.Database = newTestDatabase(newTestEntry("Title", "Mail", "UserName", "alice", "Password", "old"))
+	m.Database.Keepass.Content.Meta.HistoryMaxItems = 10
+	m.Database.AddCredentialsToDatabase(m.Configuration, "password")
+	writeTestDatabase(t, m.Configuration, m.Database)
+
+	previous := testEntry(t, m.Database, "Mail").FullEntry
+	start := time.Now()
+	if err := m.updateEntryField(testEntry(t, m.Database, "Mail"), "Password", "new"); err != nil {
+		t.Fatalf("updateEntryField() failed: %s", err)
+	}
+
+	// The previous version is saved into the history
+	saved := NewDatabase()
+	saved.AddCredentialsToDatabase(m.Configuration, "password")
+	if err := saved.OpenDatabase(m.Configuration); err != nil {
+		t.Fatal(err)
+	}
+	saved.IterateDatabase()
+	e := testEntry(t, saved, "Mail")
+	if got := e.FullEntry.GetPassword(); got != "new" {
+		t.Errorf("saved password %q, want new", got)
+	}
+	if len(e.FullEntry.Histories) != 1 || len(e.FullEntry.Histories[0].Entries) != 1 {
+		t.Fatalf("saved history %+v, want one version", e.FullEntry.Histories)
+	}
+	version := e.FullEntry.Histories[0].Entries[0]
+	if version.GetPassword() != "old" || version.GetContent("UserName") != "alice" || !version.UUID.Compare(previous.UUID) {
+		t.Errorf("history version with password %q and username %q, want the previous version", version.GetPassword(), version.GetContent("UserName"))
+	}
+	if modified := testEntry(t, m.Database, "Mail").FullEntry.Times.LastModificationTime; modified.Time.Before(start) {
+		t.Errorf("last modification time %v, want it updated", modified.Time)
+	}
+
+	// Fields saved without history, as the HOTP counter, don't push a version
+	if err := m.saveEntryField(testEntry(t, m.Database, "Mail"), "Password", "newer", false); err != nil {
+		t.Fatalf("saveEntryField() failed: %s", err)
+	}
+	if e := testEntry(t, m.Database, "Mail"); len(e.FullEntry.Histories[0].Entries) != 1 {
+		t.Errorf("history of %d versions, want 1", len(e.FullEntry.Histories[0].Entries))
+	}
+}
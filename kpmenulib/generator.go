@@ -0,0 +1,139 @@
+package kpmenulib
+
+import (
+	"crypto/rand"
+	_ "embed" // Word list of passphrases
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Character classes of generated passwords
+const (
+	GeneratorLowercase = "abcdefghijklmnopqrstuvwxyz"
+	GeneratorUppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	GeneratorDigits    = "0123456789"
+	GeneratorSymbols   = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	GeneratorSimilar   = "0OoIl1|" // Look-alike characters
+)
+
+// EFF large word list, see https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed eff_large_wordlist.txt
+var generatorWordList string
+
+// GeneratorPolicy describes how to generate a password
+type GeneratorPolicy struct {
+	Length         int    // Length of passwords
+	Lowercase      bool   // Use lowercase letters
+	Uppercase      bool   // Use uppercase letters
+	Digits         bool   // Use digits
+	Symbols        bool   // Use symbols
+	ExcludeSimilar bool   // Exclude look-alike characters
+	Words          int    // Number of words, if set a passphrase is generated
+	Separator      string // Separator of passphrase words
+}
+
+// GeneratePassword generates a random password, or passphrase, with the given policy
+func GeneratePassword(policy GeneratorPolicy) (string, error) {
+	if policy.Words > 0 {
+		return generatePassphrase(policy)
+	}
+	if policy.Length <= 0 {
+		return "", errors.New("the length of the password must be greater than 0")
+	}
+
+	// Prepare enabled classes
+	var classes []string
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{
+		{policy.Lowercase, GeneratorLowercase},
+		{policy.Uppercase, GeneratorUppercase},
+		{policy.Digits, GeneratorDigits},
+		{policy.Symbols, GeneratorSymbols},
+	} {
+		if !class.enabled {
+			continue
+		}
+		chars := class.chars
+		if policy.ExcludeSimilar {
+			chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(GeneratorSimilar, r) {
+					return -1
+				}
+				return r
+			}, chars)
+		}
+		classes = append(classes, chars)
+	}
+	if len(classes) == 0 {
+		return "", errors.New("at least one character class must be enabled")
+	}
+	if policy.Length < len(classes) {
+		return "", fmt.Errorf("the length of the password must be at least %d, one character for each class", len(classes))
+	}
+
+	// One character of every class, then any character
+	password := make([]byte, policy.Length)
+	all := strings.Join(classes, "")
+	for i := range password {
+		chars := all
+		if i < len(classes) {
+			chars = classes[i]
+		}
+		n, err := randomInt(len(chars))
+		if err != nil {
+			return "", err
+		}
+		password[i] = chars[n]
+	}
+
+	// Shuffle, to do not have the classes at fixed positions
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func generatePassphrase(policy GeneratorPolicy) (string, error) {
+	list := strings.Fields(generatorWordList)
+	words := make([]string, policy.Words)
+	for i := range words {
+		n, err := randomInt(len(list))
+		if err != nil {
+			return "", err
+		}
+		words[i] = list[n]
+	}
+	return strings.Join(words, policy.Separator), nil
+}
+
+// randomInt returns a uniform random number in [0, max) read from crypto/rand
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random data: %v", err)
+	}
+	return int(n.Int64()), nil
+}
+
+// GetPolicy returns the policy with the given name, the default one if empty
+func (c *Configuration) GetPolicy(name string) (GeneratorPolicy, error) {
+	if name == "" {
+		name = c.Generator.Policy
+	}
+	// Viper keys are lowercase
+	for n, policy := range c.Generator.Policies {
+		if strings.EqualFold(n, name) {
+			return policy, nil
+		}
+	}
+	return GeneratorPolicy{}, fmt.Errorf("generator policy %s not found", name)
+}
@@ -0,0 +1,141 @@
+package kpmenulib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  GeneratorPolicy
+		classes []string
+	}{
+		{"lowercase", GeneratorPolicy{Length: 8, Lowercase: true}, []string{GeneratorLowercase}},
+		{"digits", GeneratorPolicy{Length: 1, Digits: true}, []string{GeneratorDigits}},
+		{"all classes", GeneratorPolicy{Length: 4, Lowercase: true, Uppercase: true, Digits: true, Symbols: true},
+			[]string{GeneratorLowercase, GeneratorUppercase, GeneratorDigits, GeneratorSymbols}},
+		{"long", GeneratorPolicy{Length: 64, Uppercase: true, Symbols: true},
+			[]string{GeneratorUppercase, GeneratorSymbols}},
+		{"exclude similar", GeneratorPolicy{Length: 200, Lowercase: true, Uppercase: true, Digits: true, Symbols: true, ExcludeSimilar: true},
+			[]string{GeneratorLowercase, GeneratorUppercase, GeneratorDigits, GeneratorSymbols}},
+	}
+	for _, tt := range tests {
+		password, err := GeneratePassword(tt.policy)
+		if err != nil {
+			t.Errorf("%s: GeneratePassword() failed: %v", tt.name, err)
+			continue
+		}
+		if len(password) != tt.policy.Length {
+			t.Errorf("%s: len(%q) = %d, want %d", tt.name, password, len(password), tt.policy.Length)
+		}
+		allowed := strings.Join(tt.classes, "")
+		for _, class := range tt.classes {
+			if !strings.ContainsAny(password, class) {
+				t.Errorf("%s: %q has no character of %q", tt.name, password, class)
+			}
+		}
+		for _, r := range password {
+			if !strings.ContainsRune(allowed, r) {
+				t.Errorf("%s: %q contains %q, which is not enabled", tt.name, password, r)
+			}
+			if tt.policy.ExcludeSimilar && strings.ContainsRune(GeneratorSimilar, r) {
+				t.Errorf("%s: %q contains the look-alike %q", tt.name, password, r)
+			}
+		}
+	}
+}
+
+func TestGeneratePasswordErrors(t *testing.T) {
+	tests := []struct {
+		policy GeneratorPolicy
+		want   string
+	}{
+		{GeneratorPolicy{Length: 3, Lowercase: true, Uppercase: true, Digits: true, Symbols: true},
+			"the length of the password must be at least 4, one character for each class"},
+		{GeneratorPolicy{Length: 1, Lowercase: true, Digits: true},
+			"the length of the password must be at least 2, one character for each class"},
+		{GeneratorPolicy{Lowercase: true}, "the length of the password must be greater than 0"},
+		{GeneratorPolicy{Length: -1, Lowercase: true}, "the length of the password must be greater than 0"},
+		{GeneratorPolicy{Length: 10}, "at least one character class must be enabled"},
+	}
+	for _, tt := range tests {
+		password, err := GeneratePassword(tt.policy)
+		if err == nil {
+			t.Errorf("GeneratePassword(%+v) = %q, want an error", tt.policy, password)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("GeneratePassword(%+v) error = %q, want %q", tt.policy, err, tt.want)
+		}
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	list := make(map[string]bool)
+	for _, word := range strings.Fields(generatorWordList) {
+		list[word] = true
+	}
+
+	// Some words contain a hyphen, so the separators are not in the list
+	tests := []struct {
+		words     int
+		separator string
+	}{
+		{1, "_"},
+		{6, "_"},
+		{4, " "},
+		{8, "::"},
+	}
+	for _, tt := range tests {
+		// Length and classes are ignored by passphrases
+		passphrase, err := GeneratePassword(GeneratorPolicy{Words: tt.words, Separator: tt.separator, Length: 1, Digits: true})
+		if err != nil {
+			t.Errorf("GeneratePassword(%d words) failed: %v", tt.words, err)
+			continue
+		}
+		words := strings.Split(passphrase, tt.separator)
+		if len(words) != tt.words {
+			t.Errorf("%q has %d words separated by %q, want %d", passphrase, len(words), tt.separator, tt.words)
+		}
+		for _, word := range words {
+			if !list[word] {
+				t.Errorf("%q contains %q, which is not in the word list", passphrase, word)
+			}
+		}
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	c := NewConfiguration()
+	c.Generator.Policies["Strong"] = GeneratorPolicy{Length: 32, Lowercase: true, Symbols: true}
+
+	tests := []struct {
+		name string
+		want GeneratorPolicy
+	}{
+		{"", c.Generator.Policies["default"]},
+		{"default", c.Generator.Policies["default"]},
+		{"PassPhrase", c.Generator.Policies["passphrase"]},
+		{"strong", c.Generator.Policies["Strong"]},
+		{"STRONG", c.Generator.Policies["Strong"]},
+	}
+	for _, tt := range tests {
+		got, err := c.GetPolicy(tt.name)
+		if err != nil {
+			t.Errorf("GetPolicy(%q) failed: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("GetPolicy(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := c.GetPolicy("missing"); err == nil || err.Error() != "generator policy missing not found" {
+		t.Errorf("GetPolicy(\"missing\") error = %v, want generator policy missing not found", err)
+	}
+	c.Generator.Policy = "Unknown"
+	if _, err := c.GetPolicy(""); err == nil || err.Error() != "generator policy Unknown not found" {
+		t.Errorf("GetPolicy(\"\") with an unknown default error = %v, want generator policy Unknown not found", err)
+	}
+}
@@ -0,0 +1,198 @@
+package kpmenulib
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Actions of the git credential helper
+const (
+	GitCredentialGet   = "get"
+	GitCredentialStore = "store"
+	GitCredentialErase = "erase"
+)
+
+// GitCredential is the credential described by git, see git-credential(1)
+type GitCredential struct {
+	Protocol string
+	Host     string // Host, with the port if any
+	Path     string // Path, given only with credential.useHttpPath
+	Username string
+	Password string
+}
+
+// ParseGitCredential parses the key=value lines given by git, unknown keys are ignored
+func ParseGitCredential(input string) GitCredential {
+	var c GitCredential
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "protocol":
+			c.Protocol = parts[1]
+		case "host":
+			c.Host = parts[1]
+		case "path":
+			c.Path = parts[1]
+		case "username":
+			c.Username = parts[1]
+		case "password":
+			c.Password = parts[1]
+		case "url":
+			if u, err := url.Parse(parts[1]); err == nil {
+				c.Protocol, c.Host, c.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+				if u.User != nil {
+					c.Username = u.User.Username()
+				}
+			}
+		}
+	}
+	return c
+}
+
+// URL returns the URL of the credential, without the username
+func (c GitCredential) URL() string {
+	u := url.URL{Scheme: c.Protocol, Host: c.Host}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	return u.String()
+}
+
+// Match checks if the URL and the username of the entry, with their placeholders resolved, match the credential.
+// The protocol and the path are checked only if the entry URL has them, the path
+// of the entry matches the same path or its subpaths
+func (c GitCredential) Match(db *Database, e *Entry) bool {
+	u, err := parseURL(db.EntryValue(e, "URL"))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "" && c.Protocol != "" && !strings.EqualFold(u.Scheme, c.Protocol) {
+		return false
+	}
+	if !strings.EqualFold(strings.TrimPrefix(u.Host, "www."), strings.TrimPrefix(c.Host, "www.")) {
+		return false
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" && c.Path != "" && !matchGitPath(path, c.Path) {
+		return false
+	}
+	return c.Username == "" || db.EntryValue(e, "UserName") == c.Username
+}
+
+// matchGitPath checks if the path is the repository path or one of its parents,
+// comparing whole segments and ignoring the .git suffix
+func matchGitPath(path string, repository string) bool {
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	repository = strings.TrimSuffix(strings.Trim(repository, "/"), ".git")
+	return repository == path || strings.HasPrefix(repository, path+"/")
+}
+
+// gitCredential executes the git credential helper action with the credential given by git
+func (m *Menu) gitCredential() *ErrorDatabase {
+	args := m.Configuration.Flags.CommandArgs
+	if len(args) != 1 {
+		return NewErrorDatabase("usage: kpmenu git-credential get|store|erase", nil, false)
+	}
+	switch args[0] {
+	case GitCredentialGet, GitCredentialStore, GitCredentialErase:
+	default:
+		return NewErrorDatabase(fmt.Sprintf("unknown git credential action %s", args[0]), nil, false)
+	}
+	credential := ParseGitCredential(m.Input)
+	if credential.Host == "" {
+		return NewErrorDatabase("git didn't give the host of the credential", nil, false)
+	}
+
+	var entries []*Entry
+	for i := range m.Database.Entries {
+		if credential.Match(m.Database, &m.Database.Entries[i]) {
+			entries = append(entries, &m.Database.Entries[i])
+		}
+	}
+
+	switch args[0] {
+	case GitCredentialGet:
+		return m.gitCredentialGet(credential, entries)
+	case GitCredentialStore:
+		return m.gitCredentialStore(credential, entries)
+	case GitCredentialErase:
+		// Rejected credentials are not deleted, the entry could be used by anything else
+		log.Printf("git credential erase is ignored, entries are never deleted")
+	}
+	return nil
+}
+
+// gitCredentialGet prints the username and the password of the matching entry,
+// the user chooses the entry if more than one matches
+func (m *Menu) gitCredentialGet(credential GitCredential, entries []*Entry) *ErrorDatabase {
+	if len(entries) == 0 {
+		// Nothing printed, git asks for the credential
+		log.Printf("no entry found for %s", credential.URL())
+		return nil
+	}
+
+	entry := entries[0]
+	if len(entries) > 1 {
+		items := make([]string, len(entries))
+		for i, e := range entries {
+			items[i] = formatEntry(m.Database, m.Configuration.Style.FormatEntry, e)
+		}
+		index, err := PromptChoice(m, m.Configuration.Style.TextEntry, items)
+		if err.Cancelled {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to select entry: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+		if index < 0 {
+			return NewErrorDatabase("selected entry not found", nil, false)
+		}
+		entry = entries[index]
+	}
+
+	log.Printf("sent git credential of entry %s", entry.FullPath())
+	_, err := fmt.Fprintf(m.Output, "username=%s\npassword=%s\n", m.Database.EntryValue(entry, "UserName"), m.Database.EntryValue(entry, "Password"))
+	if err != nil {
+		return NewErrorDatabase("failed to print credential: %s", err, false)
+	}
+	return nil
+}
+
+// gitCredentialStore updates the password of the matching entry,
+// or adds a new entry into the root group if none matches
+func (m *Menu) gitCredentialStore(credential GitCredential, entries []*Entry) *ErrorDatabase {
+	if credential.Username == "" || credential.Password == "" {
+		return nil
+	}
+
+	switch len(entries) {
+	case 0:
+		groups := m.Database.Groups()
+		if len(groups) == 0 {
+			return NewErrorDatabase("the database has no groups", nil, false)
+		}
+		entry := gokeepasslib.NewEntry()
+		SetEntryValue(&entry, "Title", credential.Host, false)
+		SetEntryValue(&entry, "UserName", credential.Username, false)
+		SetEntryValue(&entry, "Password", credential.Password, true)
+		SetEntryValue(&entry, "URL", credential.URL(), false)
+		return m.insertEntry(&groups[0], entry)
+	case 1:
+		if m.Database.EntryValue(entries[0], "Password") == credential.Password {
+			// Already stored, a referenced password is compared resolved
+			return nil
+		}
+		return m.updateEntryField(entries[0], "Password", credential.Password)
+	}
+	log.Printf("git credential not stored, %d entries match %s", len(entries), credential.URL())
+	return nil
+}
@@ -0,0 +1,118 @@
+package kpmenulib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGitCredential(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  GitCredential
+	}{
+		{
+			"fields",
+			"protocol=https\nhost=github.com\npath=org/repo.git\nusername=alice\npassword=secret\n",
+			GitCredential{Protocol: "https", Host: "github.com", Path: "org/repo.git", Username: "alice", Password: "secret"},
+		},
+		{
+			"url",
+			"url=https://alice@git.example.com:8443/org/repo.git\n",
+			GitCredential{Protocol: "https", Host: "git.example.com:8443", Path: "org/repo.git", Username: "alice"},
+		},
+		{
+			"unknown keys and invalid lines",
+			"protocol=https\nwwwauth[]=Basic\ninvalid\nhost=github.com\n",
+			GitCredential{Protocol: "https", Host: "github.com"},
+		},
+		{
+			"value with =",
+			"host=example.com\npassword=a=b\n",
+			GitCredential{Host: "example.com", Password: "a=b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseGitCredential(tt.input); got != tt.want {
+				t.Errorf("ParseGitCredential() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitCredentialMatch(t *testing.T) {
+	db := newTestDatabase(newTestEntry("Title", "GitHub", "UserName", "alice", "URL", "https://github.com/org"))
+	entry := func(url string, username string) *Entry {
+		return &Entry{FullEntry: newTestEntry("URL", url, "UserName", username, "Host", "github.com")}
+	}
+	reference := "{REF:A@I:" + db.Entries[0].HexUUID() + "}"
+	credential := GitCredential{Protocol: "https", Host: "github.com", Path: "org/repo.git", Username: "alice"}
+
+	tests := []struct {
+		name       string
+		entry      *Entry
+		credential GitCredential
+		want       bool
+	}{
+		{"host", entry("https://github.com", "alice"), credential, true},
+		{"www", entry("https://www.github.com", "alice"), credential, true},
+		{"www of the credential", entry("https://github.com", "alice"), GitCredential{Protocol: "https", Host: "www.github.com"}, true},
+		{"host case", entry("https://GitHub.com", "alice"), credential, true},
+		{"other host", entry("https://gitlab.com", "alice"), credential, false},
+		{"without scheme", entry("//github.com", "alice"), credential, true},
+		{"scheme mismatch", entry("ssh://github.com", "alice"), credential, false},
+		{"scheme not given by git", entry("ssh://github.com", "alice"), GitCredential{Host: "github.com"}, true},
+		{"host without scheme", entry("github.com", "alice"), credential, true},
+		{"host and path without scheme", entry("github.com/org", "alice"), credential, true},
+		{"other path without scheme", entry("github.com/other", "alice"), credential, false},
+		{"host with port", entry("git.example.com:8443", "alice"), GitCredential{Protocol: "https", Host: "git.example.com:8443"}, true},
+		{"port mismatch", entry("git.example.com", "alice"), GitCredential{Protocol: "https", Host: "git.example.com:8443"}, false},
+		{"placeholder", entry("https://{S:Host}/org", "alice"), credential, true},
+		{"reference", entry(reference, "alice"), credential, true},
+		{"username reference", entry("https://github.com", "{REF:U@I:"+db.Entries[0].HexUUID()+"}"), credential, true},
+		{"unresolved placeholder", entry("https://{S:Missing}", "alice"), credential, false},
+		{"empty", entry("", "alice"), credential, false},
+		{"username mismatch", entry("https://github.com", "bob"), credential, false},
+		{"username not given by git", entry("https://github.com", "bob"), GitCredential{Protocol: "https", Host: "github.com"}, true},
+		{"same path", entry("https://github.com/org/repo", "alice"), credential, true},
+		{"same path with .git", entry("https://github.com/org/repo.git", "alice"), credential, true},
+		{"parent path", entry("https://github.com/org/", "alice"), credential, true},
+		{"path prefix of a segment", entry("https://github.com/org/rep", "alice"), credential, false},
+		{"longer segment", entry("https://github.com/org/repo", "alice"), GitCredential{Protocol: "https", Host: "github.com", Path: "org/repo2"}, false},
+		{"longer name", entry("https://github.com/org/repo", "alice"), GitCredential{Protocol: "https", Host: "github.com", Path: "org/repository.git"}, false},
+		{"other path", entry("https://github.com/other/repo", "alice"), credential, false},
+		{"path not given by git", entry("https://github.com/org/repo", "alice"), GitCredential{Protocol: "https", Host: "github.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.credential.Match(db, tt.entry); got != tt.want {
+				t.Errorf("Match(%s) = %v, want %v", tt.entry.FullEntry.GetContent("URL"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitCredentialStoreReference(t *testing.T) {
+	m := NewMenu()
+	m.Configuration.Database.Database = filepath.Join(t.TempDir(), "test.kdbx")
+	m.Database = newTestDatabase(
+		newTestEntry("Title", "Target", "Password", "secret"),
+		newTestEntry("Title", "Git", "UserName", "alice", "URL", "https://github.com"),
+	)
+	git := testEntry(t, m.Database, "Git")
+	reference := "{REF:P@I:" + testEntry(t, m.Database, "Target").HexUUID() + "}"
+	SetEntryValue(&git.FullEntry, "Password", reference, true)
+
+	// The resolved password given back by git is already stored, the database isn't saved
+	credential := GitCredential{Protocol: "https", Host: "github.com", Username: "alice", Password: "secret"}
+	if err := m.gitCredentialStore(credential, []*Entry{git}); err != nil {
+		t.Fatalf("gitCredentialStore() failed: %s", err.String())
+	}
+	if got := git.FullEntry.GetPassword(); got != reference {
+		t.Errorf("password = %q, want the reference %q", got, reference)
+	}
+	if len(git.FullEntry.Histories) != 0 {
+		t.Errorf("%d history versions pushed, want none", len(git.FullEntry.Histories))
+	}
+}
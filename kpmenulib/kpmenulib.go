@@ -3,8 +3,11 @@ package kpmenulib
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -23,7 +26,10 @@ func Initialize() *Menu {
 	}
 
 	// Load cli flags
-	if quit := handleCli(menu); quit == true {
+	if quit, exitCode := handleCli(menu); quit == true {
+		if exitCode != ExitOK {
+			os.Exit(exitCode)
+		}
 		return nil
 	}
 
@@ -36,36 +42,47 @@ func Initialize() *Menu {
 }
 
 // Execute is the function used to open the database (if necessary) and open the menu
-// returns true if the program should exit
-func Execute(menu *Menu) bool {
-	// Open database
-	if menu.Database.Loaded == false {
+// returns the error, the program should exit if it is fatal
+func Execute(menu *Menu) *ErrorDatabase {
+	// Open database, browser requests open it only if asked
+	if menu.Database.Loaded == false && menu.Configuration.Flags.Command != CommandBrowserHost {
 		if err := menu.OpenDatabase(); err != nil {
 			log.Print(err)
-			return err.Fatal
+			return err
 		}
 	}
 
-	// Open menu
-	if err := menu.OpenMenu(); err != nil {
+	// Execute the command or open menu
+	var err *ErrorDatabase
+	switch menu.Configuration.Flags.Command {
+	case CommandGet:
+		err = menu.getEntryValue()
+	case CommandList, CommandSearch:
+		err = menu.listEntries()
+	case CommandGitCredential:
+		err = menu.gitCredential()
+	case CommandBrowserHost:
+		err = menu.browserAction()
+	default:
+		err = menu.OpenMenu()
+	}
+	if err != nil {
 		log.Print(err)
-		return err.Fatal
+		return err
 	}
-
-	// Non-fatal exit
-	return false
+	return nil
 }
 
 // Show checks if the database configuration is changed, if so it will re-open the database
-// returns true if the program should exit
-func Show(menu *Menu) bool {
+// returns the error, the program should exit if it is fatal
+func Show(menu *Menu) *ErrorDatabase {
 	// Be sure that the database configuration is the same, otherwise a Run is necessary
 	copiedDatabase := menu.Configuration.Database
 
 	// Re handle configuration and update it if changed
 	if err := handleConfiguration(menu, true); err != nil {
 		log.Print(err)
-		return true
+		return NewErrorDatabase("%s", err, true)
 	}
 	menu.Configuration.ParseFlags(menu.CliArguments)
 
@@ -77,8 +94,8 @@ func Show(menu *Menu) bool {
 		log.Printf("database configuration is changed, re-opening the database")
 	}
 
-	// Check if the cache is not timed out, if not a daemon
-	if !menu.Configuration.Flags.Daemon {
+	// Check if the cache is not timed out, if not a daemon or if serving SSH keys
+	if menu.cacheTimesOut() {
 		if menu.Configuration.General.NoCache {
 			// Cache disabled
 			menu.Database.Loaded = false
@@ -92,8 +109,9 @@ func Show(menu *Menu) bool {
 			difference := int(time.Now().Sub(menu.CacheStart).Seconds())
 			if difference < menu.Configuration.General.CacheTimeout {
 				// Cache is valid
-				if !menu.Configuration.General.CacheOneTime {
-					// Set new cache start if cache one time is false
+				if !menu.Configuration.General.CacheOneTime && menu.Configuration.Flags.Command != CommandBrowserHost {
+					// Set new cache start if cache one time is false,
+					// the periodic requests of the browser don't keep the database open
 					menu.CacheStart = time.Now()
 				}
 			} else {
@@ -126,29 +144,49 @@ func handleConfiguration(menu *Menu, parseOnly bool) error {
 }
 
 func checkFlags(menu *Menu) error {
+	// Requests to the running kpmenu don't use the database and the menu
+	if menu.Configuration.Flags.Request() != RequestShow {
+		return nil
+	}
+
+	if menu.Configuration.TerminalBound() {
+		if menu.Configuration.Flags.Daemon {
+			return errors.New("tty, fzf and osc52 use the terminal, they can't be used by the daemon")
+		}
+		// Executed in this process, without caching the database
+		menu.Configuration.General.NoCache = true
+	}
+
+	switch menu.Configuration.Flags.Command {
+	case "", CommandGet, CommandList, CommandSearch, CommandGitCredential, CommandBrowserHost:
+		// Open the database
+	case CommandGenerate:
+		// The database and the menu are not used
+		return checkClipboardFlags(menu)
+	default:
+		return fmt.Errorf("unknown command %s", menu.Configuration.Flags.Command)
+	}
+
 	// Check if database has been selected
 	if menu.Configuration.Database.Database == "" {
 		// Database not found
 		return errors.New("you must select a database with -d or via config")
 	}
 
-	// Check if rofi is installed
-	if menu.Configuration.General.Menu == PromptRofi {
-		cmd := exec.Command("which", "rofi")
-		err := cmd.Run()
-		if err != nil {
-			log.Printf("rofi not found, using dmenu")
-			menu.Configuration.General.Menu = PromptDmenu
-		}
-	} else if menu.Configuration.General.Menu == PromptWofi {
-		cmd := exec.Command("which", "wofi")
+	// Check if the menu is supported
+	backend, ok := prompterBackends[menu.Configuration.General.Menu]
+	if !ok {
+		return errors.New("invalid menu option, exiting")
+	}
+
+	// Check if the menu is installed, otherwise use dmenu
+	if backend.Executable != "" && menu.Configuration.General.Menu != PromptDmenu {
+		cmd := exec.Command("which", backend.Executable)
 		err := cmd.Run()
 		if err != nil {
-			log.Printf("wofi not found, using dmenu")
+			log.Printf("%s not found, using dmenu", backend.Executable)
 			menu.Configuration.General.Menu = PromptDmenu
 		}
-	} else if menu.Configuration.General.Menu != PromptDmenu && menu.Configuration.General.Menu != PromptCustom {
-		return errors.New("invalid menu option, exiting")
 	}
 
 	if menu.Configuration.General.Menu == PromptDmenu {
@@ -160,6 +198,45 @@ func checkFlags(menu *Menu) error {
 		}
 	}
 
+	// Only the entries of a group are exposed to the session bus
+	if menu.Configuration.General.SecretService && strings.Trim(menu.Configuration.General.SecretServiceGroup, "/") == "" {
+		return errors.New("secretService requires secretServiceGroup, the group exposed as collection")
+	}
+
+	// Commands print values, the clipboard and autotype are not used
+	if menu.Configuration.Flags.Command != "" {
+		return nil
+	}
+
+	if err := checkClipboardFlags(menu); err != nil {
+		return err
+	}
+
+	if menu.Configuration.General.Autotype {
+		switch menu.Configuration.General.AutotypeTool {
+		case AutotypeToolXdotool, AutotypeToolWtype, AutotypeToolYdotool:
+			// Check if the autotype tool is installed
+			cmd := exec.Command("which", menu.Configuration.General.AutotypeTool)
+			err := cmd.Run()
+			if err != nil {
+				return fmt.Errorf("%s not found, exiting", menu.Configuration.General.AutotypeTool)
+			}
+		case AutotypeToolCustom:
+			// Check if CustomAutotypeText and CustomAutotypeKey are set
+			if menu.Configuration.Executable.CustomAutotypeText == "" {
+				return errors.New("when autotypeTool is set to custom, CustomAutotypeText must be set")
+			}
+			if menu.Configuration.Executable.CustomAutotypeKey == "" {
+				return errors.New("when autotypeTool is set to custom, CustomAutotypeKey must be set")
+			}
+		default:
+			return errors.New("invalid autotype tool, exiting")
+		}
+	}
+	return nil
+}
+
+func checkClipboardFlags(menu *Menu) error {
 	if menu.Configuration.General.ClipboardTool == ClipboardToolWlclipboard {
 		// Check if wl-clipboard is installed
 		cmd := exec.Command("which", "wl-copy")
@@ -189,13 +266,37 @@ func checkFlags(menu *Menu) error {
 	return nil
 }
 
-// Returns true if the program should exit
-func handleCli(menu *Menu) bool {
+// Returns true if the program should exit, with the exit code
+func handleCli(menu *Menu) (bool, int) {
 	// Check for version flag
 	if menu.Configuration.Flags.Version {
 		// Print version and exit
 		fmt.Println(Version)
-		return true
+		return true, ExitOK
+	}
+
+	// Read the credential given by git, the server can't read the standard input of the client
+	if menu.Configuration.Flags.Command == CommandGitCredential {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Printf("failed to read git credential: %v", err)
+			return true, ExitError
+		}
+		menu.Input = string(input)
+	}
+
+	// Check for generate command
+	if menu.Configuration.Flags.Command == CommandGenerate {
+		policy := ""
+		if len(menu.Configuration.Flags.CommandArgs) > 0 {
+			policy = menu.Configuration.Flags.CommandArgs[0]
+		}
+		if _, err := menu.generatePassword(policy); err != nil {
+			log.Print(err.String())
+			return true, ExitError
+		}
+		menu.WaitGroup.Wait()
+		return true, ExitOK
 	}
-	return false
+	return false, ExitOK
 }
@@ -0,0 +1,44 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHandleCliGenerate(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantOutput bool
+	}{
+		{"default policy", nil, ExitOK, true},
+		{"named policy", []string{"passphrase"}, ExitOK, true},
+		{"unknown policy", []string{"unknown"}, ExitError, false},
+		{"invalid policy", []string{"invalid"}, ExitError, false},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		m := NewMenu()
+		m.Output = &output
+		m.Configuration.General.ClipboardTool = ClipboardToolStdout
+		m.Configuration.Generator.Policies["invalid"] = GeneratorPolicy{Length: 10}
+		m.Configuration.Flags.Command = CommandGenerate
+		m.Configuration.Flags.CommandArgs = tt.args
+
+		quit, exitCode := handleCli(m)
+		if !quit || exitCode != tt.wantCode {
+			t.Errorf("%s: handleCli() = %v, %d, want true, %d", tt.name, quit, exitCode, tt.wantCode)
+		}
+		if got := output.Len() > 0; got != tt.wantOutput {
+			t.Errorf("%s: printed password %q, want printed %v", tt.name, output.String(), tt.wantOutput)
+		}
+	}
+
+	// Other commands are executed by the server
+	m := NewMenu()
+	m.Configuration.Flags.Command = CommandList
+	if quit, exitCode := handleCli(m); quit || exitCode != ExitOK {
+		t.Errorf("list: handleCli() = %v, %d, want false, %d", quit, exitCode, ExitOK)
+	}
+}
@@ -0,0 +1,252 @@
+package kpmenulib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// EntryLookup describes how to find an entry of the database, set fields must all match
+type EntryLookup struct {
+	Query string // Path, title or URL of the entry
+	UUID  string // UUID of the entry, hex or base64 encoded
+	URL   string // URL of the entry, matched by host
+}
+
+// FindEntry returns the only entry matching the lookup.
+// The query is matched with the path of entries (e.g. Group/Title),
+// if nothing matches with their title and then with their URL
+func (db *Database) FindEntry(lookup EntryLookup) (*Entry, error) {
+	if lookup.Query == "" && lookup.UUID == "" && lookup.URL == "" {
+		return nil, errors.New("no entry given, use a path, title, UUID or URL")
+	}
+
+	var entries []*Entry
+	for i := range db.Entries {
+		entries = append(entries, &db.Entries[i])
+	}
+
+	if lookup.UUID != "" {
+		uuid, err := ParseUUID(lookup.UUID)
+		if err != nil {
+			return nil, err
+		}
+		entries = filterEntries(entries, func(e *Entry) bool {
+			return e.UUID.Compare(uuid)
+		})
+	}
+	if lookup.URL != "" {
+		entries = filterEntries(entries, func(e *Entry) bool {
+			return matchURL(e.FullEntry.GetContent("URL"), lookup.URL)
+		})
+	}
+	if lookup.Query != "" {
+		query := strings.Trim(lookup.Query, "/")
+		matched := filterEntries(entries, func(e *Entry) bool {
+			return e.FullPath() == query
+		})
+		if len(matched) == 0 {
+			matched = filterEntries(entries, func(e *Entry) bool {
+				return e.FullEntry.GetTitle() == lookup.Query
+			})
+		}
+		if len(matched) == 0 {
+			matched = filterEntries(entries, func(e *Entry) bool {
+				return matchURL(e.FullEntry.GetContent("URL"), lookup.Query)
+			})
+		}
+		entries = matched
+	}
+
+	switch len(entries) {
+	case 0:
+		return nil, errors.New("no entry found")
+	case 1:
+		return entries[0], nil
+	}
+	var matches []string
+	for _, e := range entries {
+		matches = append(matches, fmt.Sprintf("%s (%s)", e.FullPath(), e.HexUUID()))
+	}
+	return nil, fmt.Errorf("the entry is ambiguous, %d entries match: %s", len(entries), strings.Join(matches, ", "))
+}
+
+// EntryFilter describes which entries of the database are listed, set fields must all match
+type EntryFilter struct {
+	Query   string // Text contained into the path, title, username, URL or tags, case insensitive
+	Group   string // Path of the group containing the entries, subgroups included
+	Tag     string // Tag of the entries, case insensitive
+	Expired bool   // List only expired entries
+}
+
+// ListEntries returns the entries matching the filter
+func (db *Database) ListEntries(filter EntryFilter) []*Entry {
+	query := strings.ToLower(filter.Query)
+	group := strings.Trim(filter.Group, "/")
+	var entries []*Entry
+	for i := range db.Entries {
+		e := &db.Entries[i]
+		if group != "" && e.Path != group && !strings.HasPrefix(e.Path, group+"/") {
+			continue
+		}
+		if filter.Tag != "" && !containsFold(e.Tags(), filter.Tag) {
+			continue
+		}
+		if filter.Expired && !e.Expired() {
+			continue
+		}
+		if query != "" {
+			text := strings.Join(append([]string{
+				e.FullPath(),
+				e.FullEntry.GetContent("UserName"),
+				e.FullEntry.GetContent("URL"),
+			}, e.Tags()...), "\n")
+			if !strings.Contains(strings.ToLower(text), query) {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// EntryInfo describes an entry without its secret values, used by the JSON output
+type EntryInfo struct {
+	UUID     string            `json:"uuid"`
+	Title    string            `json:"title"`
+	UserName string            `json:"username"`
+	URL      string            `json:"url"`
+	Group    string            `json:"group"`
+	Tags     []string          `json:"tags"`
+	Fields   []string          `json:"fields"`
+	Expired  bool              `json:"expired"`
+	Values   map[string]string `json:"values,omitempty"` // Values of every field, only if requested
+}
+
+// Info returns the description of the entry, with the values of its fields if secrets is true
+func (e *Entry) Info(secrets bool) EntryInfo {
+	info := EntryInfo{
+		UUID:     e.HexUUID(),
+		Title:    e.FullEntry.GetTitle(),
+		UserName: e.FullEntry.GetContent("UserName"),
+		URL:      e.FullEntry.GetContent("URL"),
+		Group:    e.Path,
+		Tags:     e.Tags(),
+		Fields:   []string{},
+		Expired:  e.Expired(),
+	}
+	if secrets {
+		info.Values = make(map[string]string)
+	}
+	for _, v := range e.FullEntry.Values {
+		info.Fields = append(info.Fields, v.Key)
+		if secrets {
+			info.Values[v.Key] = v.Value.Content
+		}
+	}
+	sort.Strings(info.Fields)
+	return info
+}
+
+// Tags returns the tags of the entry, KeePass separates them by ; or ,
+func (e *Entry) Tags() []string {
+	tags := []string{}
+	for _, tag := range strings.FieldsFunc(e.FullEntry.Tags, func(r rune) bool {
+		return r == ';' || r == ','
+	}) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Expired checks if the entry expires and its expiry time is passed
+func (e *Entry) Expired() bool {
+	times := e.FullEntry.Times
+	return times.Expires.Bool && times.ExpiryTime != nil && times.ExpiryTime.Time.Before(time.Now())
+}
+
+func containsFold(items []string, s string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterEntries(entries []*Entry, match func(*Entry) bool) []*Entry {
+	var filtered []*Entry
+	for _, e := range entries {
+		if match(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// FullPath returns the path of the entry, made by the path of its group and its title
+func (e *Entry) FullPath() string {
+	if e.Path == "" {
+		return e.FullEntry.GetTitle()
+	}
+	return e.Path + "/" + e.FullEntry.GetTitle()
+}
+
+// HexUUID returns the UUID of the entry hex encoded, as shown by KeePassXC
+func (e *Entry) HexUUID() string {
+	return hex.EncodeToString(e.UUID[:])
+}
+
+// ParseUUID parses an UUID hex encoded, with or without dashes, or base64 encoded as into KeePass XML
+func ParseUUID(s string) (gokeepasslib.UUID, error) {
+	var uuid gokeepasslib.UUID
+	data, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(data) != len(uuid) {
+		data, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || len(data) != len(uuid) {
+		return uuid, fmt.Errorf("invalid UUID %s", s)
+	}
+	copy(uuid[:], data)
+	return uuid, nil
+}
+
+// matchURL checks if the URL of the entry has the same host of the given URL,
+// URLs without a host must be equal
+func matchURL(entryURL string, target string) bool {
+	if entryURL == "" || target == "" {
+		return false
+	}
+	if strings.EqualFold(entryURL, target) {
+		return true
+	}
+	entryHost, targetHost := urlHost(entryURL), urlHost(target)
+	return entryHost != "" && entryHost == targetHost
+}
+
+// urlHost returns the lowercase host of the URL without "www.", empty if it has no host
+func urlHost(s string) string {
+	u, err := parseURL(s)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// parseURL parses the URL of an entry.
+// URLs without a scheme, as github.com/org, are parsed as starting with the host
+func parseURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") && !strings.HasPrefix(s, "//") {
+		s = "//" + s
+	}
+	return url.Parse(s)
+}
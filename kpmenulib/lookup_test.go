@@ -0,0 +1,167 @@
+package kpmenulib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+func TestURLHost(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/org/repo", "github.com"},
+		{"https://WWW.GitHub.com:8443", "github.com"},
+		{"github.com", "github.com"},
+		{"www.github.com/org", "github.com"},
+		{"github.com:8443/org", "github.com"},
+		{"//github.com", "github.com"},
+		{"ssh://git@github.com", "github.com"},
+		{"", ""},
+		{"not a host", ""},
+	}
+	for _, tt := range tests {
+		if got := urlHost(tt.url); got != tt.want {
+			t.Errorf("urlHost(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMatchURL(t *testing.T) {
+	tests := []struct {
+		entry  string
+		target string
+		want   bool
+	}{
+		{"https://github.com/login", "https://github.com", true},
+		{"https://github.com", "github.com", true},
+		{"github.com", "https://github.com/org/repo", true},
+		{"github.com/login", "github.com", true},
+		{"www.github.com", "GITHUB.COM", true},
+		{"github.com", "gitlab.com", false},
+		{"https://github.com", "https://github.com.evil.com", false},
+		{"", "github.com", false},
+		{"github.com", "", false},
+		{"some notes", "Some Notes", true},
+		{"some notes", "other notes", false},
+	}
+	for _, tt := range tests {
+		if got := matchURL(tt.entry, tt.target); got != tt.want {
+			t.Errorf("matchURL(%q, %q) = %v, want %v", tt.entry, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWindowURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		title string
+		want  bool
+	}{
+		{"https://www.github.com/login", "Sign in to GitHub.com - Firefox", true},
+		{"github.com", "github.com - Firefox", true},
+		{"github.com", "gist.github.com - Firefox", true},
+		{"github.com", "Login at github.com.", true},
+		{"github.com", "gitlab.com - Firefox", false},
+		{"", "github.com - Firefox", false},
+		// Hosts are matched on label boundaries
+		{"github.com", "github.com.evil.org - Firefox", false},
+		{"github.com", "evil-github.com - Firefox", false},
+		{"github.com", "mygithub.com - Firefox", false},
+		{"github.com", "github.community - Firefox", false},
+		// Hosts without a dot must match exactly
+		{"https://go", "Go - Documentation", false},
+		{"mail", "Gmail - Inbox", false},
+		{"http://localhost:8080", "localhost - Firefox", false},
+		{"http://localhost:8080", "localhost", true},
+		// Placeholders are resolved, unresolved ones never match
+		{"https://{USERNAME}.example.com", "alice.example.com - Firefox", true},
+		{"https://{USERNAME}.example.com", "bob.example.com - Firefox", false},
+		{"{UNKNOWN}", "{unknown} - Firefox", false},
+	}
+	for _, tt := range tests {
+		db := newTestDatabase(newTestEntry("Title", "Entry", "UserName", "alice", "URL", tt.url))
+		if got := MatchWindow(db, testEntry(t, db, "Entry"), tt.title); got != tt.want {
+			t.Errorf("MatchWindow(%q, %q) = %v, want %v", tt.url, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWindowAssociation(t *testing.T) {
+	tests := []struct {
+		window string
+		title  string
+		want   bool
+	}{
+		{"*Firefox*", "GitHub - Mozilla Firefox", true},
+		{"//^git(hub|lab)//", "GitLab - Firefox", true},
+		{"{TITLE} - *", "Bank - Firefox", true},
+		{"{TITLE} - *", "Mail - Firefox", false},
+		{"", "Bank - Firefox", false},
+	}
+	for _, tt := range tests {
+		db := newTestDatabase(newTestEntry("Title", "Bank"))
+		e := testEntry(t, db, "Bank")
+		e.FullEntry.AutoType.Associations = []gokeepasslib.AutoTypeAssociation{{Window: tt.window}}
+		if got := MatchWindow(db, e, tt.title); got != tt.want {
+			t.Errorf("MatchWindow(%q, %q) = %v, want %v", tt.window, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestFindEntry(t *testing.T) {
+	db := newTestDatabaseGroups(
+		newTestGroup("Web",
+			newTestEntry("Title", "Mail", "URL", "https://mail.example.com"),
+			newTestEntry("Title", "Forum", "URL", "https://forum.example.com"),
+		),
+		newTestGroup("Work", newTestEntry("Title", "Mail", "URL", "https://work.example.com")),
+	)
+	webMail := &db.Entries[0]
+	workMail := &db.Entries[2]
+	dashed := fmt.Sprintf("%x-%x-%x-%x-%x", workMail.UUID[:4], workMail.UUID[4:6], workMail.UUID[6:8], workMail.UUID[8:10], workMail.UUID[10:])
+
+	tests := []struct {
+		name   string
+		lookup EntryLookup
+		want   string
+	}{
+		{"path", EntryLookup{Query: "Web/Mail"}, "Web/Mail"},
+		{"path with slashes", EntryLookup{Query: "/Work/Mail/"}, "Work/Mail"},
+		{"title", EntryLookup{Query: "Forum"}, "Web/Forum"},
+		{"URL query", EntryLookup{Query: "https://forum.example.com/login"}, "Web/Forum"},
+		{"URL", EntryLookup{URL: "mail.example.com"}, "Web/Mail"},
+		{"hex UUID", EntryLookup{UUID: workMail.HexUUID()}, "Work/Mail"},
+		{"dashed UUID", EntryLookup{UUID: strings.ToUpper(dashed)}, "Work/Mail"},
+		{"base64 UUID", EntryLookup{UUID: base64.StdEncoding.EncodeToString(workMail.UUID[:])}, "Work/Mail"},
+		{"UUID and title", EntryLookup{Query: "Mail", UUID: workMail.HexUUID()}, "Work/Mail"},
+		{"URL and title", EntryLookup{Query: "Mail", URL: "https://work.example.com"}, "Work/Mail"},
+	}
+	for _, tt := range tests {
+		e, err := db.FindEntry(tt.lookup)
+		if err != nil || e.FullPath() != tt.want {
+			t.Errorf("%s: FindEntry(%+v) = %v, %v, want %s", tt.name, tt.lookup, e, err, tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		name   string
+		lookup EntryLookup
+		want   string
+	}{
+		{"nothing given", EntryLookup{}, "no entry given, use a path, title, UUID or URL"},
+		{"not found", EntryLookup{Query: "Bank"}, "no entry found"},
+		{"UUID and another title", EntryLookup{Query: "Forum", UUID: workMail.HexUUID()}, "no entry found"},
+		{"invalid UUID", EntryLookup{UUID: "abc"}, "invalid UUID abc"},
+		{"ambiguous", EntryLookup{Query: "Mail"}, fmt.Sprintf("the entry is ambiguous, 2 entries match: Web/Mail (%s), Work/Mail (%s)", webMail.HexUUID(), workMail.HexUUID())},
+	}
+	for _, tt := range errorTests {
+		if e, err := db.FindEntry(tt.lookup); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: FindEntry(%+v) = %v, %v, want the error %q", tt.name, tt.lookup, e, err, tt.want)
+		}
+	}
+}
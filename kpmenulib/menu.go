@@ -1,20 +1,37 @@
 package kpmenulib
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
 )
 
 // Menu is the main structure of kpmenu
 type Menu struct {
-	CacheStart    time.Time       // Cache start time
-	CliArguments  []string        // Arguments of kpmenu
-	Configuration *Configuration  // Configuration of kpmenu
-	Database      *Database       // Database
-	WaitGroup     *sync.WaitGroup // WaitGroup used for goroutines
+	CacheStart    time.Time           // Cache start time
+	CliArguments  []string            // Arguments of kpmenu
+	Configuration *Configuration      // Configuration of kpmenu
+	Database      *Database           // Database
+	Input         string              // Standard input of commands
+	Mutex         sync.Mutex          // Used to access the database from other goroutines
+	Output        io.Writer           // Output of the stdout clipboard tool
+	Prompter      Prompter            // Prompter used instead of the configured menu, if set
+	SSHAgent      *SSHAgent           // SSH agent serving the keys of the database, if enabled
+	WaitGroup     *sync.WaitGroup     // WaitGroup used for goroutines
+	cacheTimer    *time.Timer         // Locks the database of a daemon when the cache times out
+	now           func() time.Time    // Clock of the OTP codes
+	sleep         func(time.Duration) // Waits for the next OTP code
 }
 
 // NewMenu initializes a Menu struct
@@ -23,7 +40,10 @@ func NewMenu() *Menu {
 		CliArguments:  os.Args[1:],
 		Configuration: NewConfiguration(),
 		Database:      NewDatabase(),
+		Output:        os.Stdout,
 		WaitGroup:     new(sync.WaitGroup),
+		now:           time.Now,
+		sleep:         time.Sleep,
 	}
 }
 
@@ -62,9 +82,61 @@ func (m *Menu) OpenDatabase() *ErrorDatabase {
 	// Set database as loaded
 	m.Database.Loaded = true
 
+	// Serve the SSH keys of the database
+	if m.SSHAgent != nil {
+		m.SSHAgent.Load(m.Database)
+	}
+
+	// A daemon has no listener deadline, the timer locks the database when the cache times out
+	if m.Configuration.Flags.Daemon && m.cacheTimesOut() {
+		m.CacheStart = time.Now()
+		m.scheduleCacheTimeout(time.Duration(m.Configuration.General.CacheTimeout) * time.Second)
+	}
+
 	return nil
 }
 
+// cacheTimesOut checks if the database is locked when the cache times out,
+// a daemon keeps it open until locked unless it serves SSH keys
+func (m *Menu) cacheTimesOut() bool {
+	return !m.Configuration.Flags.Daemon || m.SSHAgent != nil
+}
+
+// scheduleCacheTimeout checks the cache after the delay, locking the database if timed out.
+// The cache start is refreshed by requests, so the check is scheduled again until it times out
+func (m *Menu) scheduleCacheTimeout(delay time.Duration) {
+	if m.cacheTimer != nil {
+		m.cacheTimer.Stop()
+	}
+	m.cacheTimer = time.AfterFunc(delay, func() {
+		m.Mutex.Lock()
+		defer m.Mutex.Unlock()
+		if !m.Database.Loaded || (m.CacheStart == time.Time{}) {
+			return
+		}
+		remaining := time.Duration(m.Configuration.General.CacheTimeout)*time.Second - time.Since(m.CacheStart)
+		if remaining > 0 {
+			m.scheduleCacheTimeout(remaining)
+			return
+		}
+		log.Printf("cache timed out, locking the database")
+		m.lockDatabase()
+	})
+}
+
+// lockDatabase drops the decrypted database, its credentials and the SSH keys
+func (m *Menu) lockDatabase() {
+	m.Database = NewDatabase()
+	m.CacheStart = time.Time{}
+	if m.cacheTimer != nil {
+		m.cacheTimer.Stop()
+		m.cacheTimer = nil
+	}
+	if m.SSHAgent != nil {
+		m.SSHAgent.Unload()
+	}
+}
+
 // OpenMenu executes dmenu to interface the user with the database
 func (m *Menu) OpenMenu() *ErrorDatabase {
 	// Prompt for menu selection
@@ -79,6 +151,10 @@ func (m *Menu) OpenMenu() *ErrorDatabase {
 	switch selectedMenu {
 	case MenuShow:
 		return m.entrySelection()
+	case MenuAddEntry:
+		return m.addEntry("")
+	case MenuGenerate:
+		return m.generateSelection()
 	case MenuReload:
 		log.Printf("reloading database")
 		if err := m.OpenDatabase(); err != nil {
@@ -86,7 +162,7 @@ func (m *Menu) OpenMenu() *ErrorDatabase {
 		}
 		return m.OpenMenu()
 	case MenuExit:
-		m.Database.Loaded = false
+		m.lockDatabase()
 		return NewErrorDatabase("exiting", nil, true)
 	}
 	return nil
@@ -108,7 +184,7 @@ func (m *Menu) entrySelection() *ErrorDatabase {
 	}
 
 	// Prompt for field selection
-	fieldValue, err := PromptFields(m, selectedEntry)
+	selectedField, err := PromptFields(m, selectedEntry)
 	if err.Cancelled {
 		if err.Error != nil {
 			return NewErrorDatabase("failed to select field: %s", err.Error, false)
@@ -116,11 +192,54 @@ func (m *Menu) entrySelection() *ErrorDatabase {
 		// Cancelled
 		return NewErrorDatabase("", nil, false)
 	}
+
+	if selectedField.Action == FieldEdit {
+		return m.editEntry(selectedEntry)
+	}
+
+	if selectedField.Action == FieldAutotype {
+		// Autotype the entry sequence
+		sequence := m.Database.AutotypeSequence(selectedEntry)
+		actions, err := ParseAutotypeSequence(sequence, m.Database.ResolvedEntry(selectedEntry))
+		if err != nil {
+			return NewErrorDatabase("failed to parse autotype sequence: %s", err, false)
+		}
+		if strings.Contains(strings.ToUpper(sequence), "{TOTP}") {
+			// The typed HOTP code can't be used again
+			if err := m.advanceHOTPCounter(selectedEntry); err != nil {
+				return err
+			}
+		}
+		if err := Autotype(m, actions); err != nil {
+			return NewErrorDatabase("failed to autotype: %s", err, false)
+		}
+		log.Printf("typed autotype sequence")
+		return nil
+	}
+
+	if selectedField.Action == FieldAddOTP {
+		return m.addOTP(selectedEntry)
+	}
+
+	if selectedField.Action == FieldUsernameOTP {
+		return m.useUsernameOTP(selectedEntry)
+	}
+
+	fieldValue := selectedField.Value
 	if fieldValue == "" {
 		// Field not found
 		return NewErrorDatabase("selected field not found", nil, false)
 	}
 
+	if m.Configuration.General.Autotype {
+		// Type the field
+		if err := Autotype(m, []AutotypeAction{{Text: fieldValue}}); err != nil {
+			return NewErrorDatabase("failed to autotype: %s", err, false)
+		}
+		log.Printf("typed field")
+		return nil
+	}
+
 	// Copy to clipboard
 	if err := CopyToClipboard(m, fieldValue); err != nil {
 		return NewErrorDatabase("failed to use clipboard manager to update clipboard: %s", err, true)
@@ -132,6 +251,508 @@ func (m *Menu) entrySelection() *ErrorDatabase {
 	return nil
 }
 
+// useUsernameOTP types the username, tab and the OTP of the entry. Without autotype the username
+// is copied and the OTP is copied after a confirmation, when the username has been used
+func (m *Menu) useUsernameOTP(entry *Entry) *ErrorDatabase {
+	username := m.Database.EntryValue(entry, "UserName")
+	if m.Configuration.General.Autotype {
+		otp, err := m.selectedOTP(entry)
+		if err != nil {
+			return err
+		}
+		if err := Autotype(m, []AutotypeAction{{Text: username}, {Key: "TAB"}, {Text: otp}}); err != nil {
+			return NewErrorDatabase("failed to autotype: %s", err, false)
+		}
+		log.Printf("typed username and otp")
+		return nil
+	}
+
+	if err := CopyToClipboard(m, username); err != nil {
+		return NewErrorDatabase("failed to use clipboard manager to update clipboard: %s", err, true)
+	}
+	log.Printf("copied username into the clipboard")
+	CleanClipboard(m, username)
+
+	const CopyOTP = "Copy OTP"
+	if _, err := PromptChoice(m, "Username copied", []string{CopyOTP}); err.Cancelled {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to confirm otp: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+
+	// Generated now, the username may have taken a while
+	otp, err := m.selectedOTP(entry)
+	if err != nil {
+		return err
+	}
+	if err := CopyToClipboard(m, otp); err != nil {
+		return NewErrorDatabase("failed to use clipboard manager to update clipboard: %s", err, true)
+	}
+	log.Printf("copied otp into the clipboard")
+	CleanClipboard(m, otp)
+	return nil
+}
+
+// addOTP prompts for an otpauth URI, typed, pasted from the clipboard or decoded from a QR code image,
+// and saves it into the otp field of the entry. A typed base32 secret is saved as TOTP with the default settings
+func (m *Menu) addOTP(entry *Entry) *ErrorDatabase {
+	const TypeURI = "Type otpauth URI or secret"
+	const PasteURI = "Paste otpauth URI from clipboard"
+	const ScanQRCode = "Read QR code image"
+	i, err := PromptChoice(m, m.Configuration.Style.TextMenu, []string{TypeURI, PasteURI, ScanQRCode})
+	if err.Cancelled || err.Error != nil || i < 0 {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select menu item: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+
+	var uri string
+	switch i {
+	case 0:
+		if uri, err = PromptInput(m, "otpauth URI or secret"); err.Cancelled || err.Error != nil {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to read otpauth uri: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+		if uri = strings.TrimSpace(uri); !strings.HasPrefix(strings.ToLower(uri), OTPAUTH+"://") {
+			uri = secretOTPAuthURI(m.Database, entry, uri)
+		}
+	case 1:
+		var errClipboard error
+		if uri, errClipboard = GetClipboard(m); errClipboard != nil {
+			return NewErrorDatabase("failed to read clipboard: %s", errClipboard, false)
+		}
+	case 2:
+		path, err := PromptInput(m, "QR code image path")
+		if err.Cancelled || err.Error != nil {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to read image path: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+		var errQR error
+		if uri, errQR = DecodeQRCode(path); errQR != nil {
+			return NewErrorDatabase("failed to decode qr code: %s", errQR, false)
+		}
+	}
+
+	// Validate the URI before saving it
+	uri = strings.TrimSpace(uri)
+	if !strings.HasPrefix(strings.ToLower(uri), OTPAUTH+"://") {
+		return NewErrorDatabase("not an otpauth uri", nil, false)
+	}
+	check := gokeepasslib.NewEntry()
+	SetEntryValue(&check, OTP, uri, true)
+	if _, err := CreateOTPAuth(check); err != nil {
+		return NewErrorDatabase("failed to add otp: %s", err, false)
+	}
+	return m.updateEntryField(entry, OTP, uri)
+}
+
+// secretOTPAuthURI returns the otpauth URI of a TOTP with the secret and the default settings,
+// labeled with the title and the username of the entry as KeePassXC does
+func secretOTPAuthURI(db *Database, entry *Entry, secret string) string {
+	title := db.EntryValue(entry, "Title")
+	label := url.PathEscape(title)
+	if username := db.EntryValue(entry, "UserName"); username != "" {
+		label += ":" + url.PathEscape(username)
+	}
+	query := url.Values{}
+	query.Set("secret", strings.ToUpper(strings.Join(strings.Fields(secret), "")))
+	query.Set("period", strconv.Itoa(DefaultOTPPeriod))
+	query.Set("digits", strconv.Itoa(DefaultOTPDigits))
+	if title != "" {
+		query.Set("issuer", title)
+	}
+	return fmt.Sprintf("%s://%s/%s?%s", OTPAUTH, TOTP, label, query.Encode())
+}
+
+// addEntry prompts for a new entry and saves it, the password is asked if empty
+func (m *Menu) addEntry(password string) *ErrorDatabase {
+	// Prompt for group selection
+	group, err := PromptGroup(m)
+	if err.Cancelled {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select group: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+	if group == nil {
+		// Group not found
+		return NewErrorDatabase("selected group not found", nil, false)
+	}
+
+	// Prompt for entry fields
+	entry := gokeepasslib.NewEntry()
+	for _, field := range []string{"Title", "UserName", "Password", "URL"} {
+		var value string
+		if field == "Password" && password != "" {
+			value = password
+		} else if field == "Password" {
+			value, err = PromptSecret(m, field)
+		} else {
+			value, err = PromptInput(m, field)
+		}
+		if err.Cancelled || err.Error != nil {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to get field: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+		if field == "Title" && value == "" {
+			return NewErrorDatabase("the title of the entry can't be empty", nil, false)
+		}
+		SetEntryValue(&entry, field, value, field == "Password")
+	}
+	return m.insertEntry(group, entry)
+}
+
+// insertEntry adds the entry into the group and saves the database
+func (m *Menu) insertEntry(group *GroupItem, entry gokeepasslib.Entry) *ErrorDatabase {
+	m.Database.AddEntry(group.Group, entry)
+	if err := m.Database.SaveDatabase(m.Configuration); err != nil {
+		// Remove the entry, it's not saved
+		group.Group.Entries = group.Group.Entries[:len(group.Group.Entries)-1]
+		m.Database.IterateDatabase()
+		return NewErrorDatabase("failed to save database: %s", err, false)
+	}
+	log.Printf("added entry %s into /%s", entry.GetTitle(), group.Path)
+	return nil
+}
+
+// generatePassword generates a password with the policy and copies it into the clipboard
+func (m *Menu) generatePassword(policyName string) (string, *ErrorDatabase) {
+	policy, err := m.Configuration.GetPolicy(policyName)
+	if err != nil {
+		return "", NewErrorDatabase("%s", err, false)
+	}
+	password, err := GeneratePassword(policy)
+	if err != nil {
+		return "", NewErrorDatabase("failed to generate password: %s", err, false)
+	}
+
+	// Copy to clipboard
+	if err := CopyToClipboard(m, password); err != nil {
+		return "", NewErrorDatabase("failed to use clipboard manager to update clipboard: %s", err, true)
+	}
+	log.Printf("copied generated password into the clipboard")
+
+	// Clean clipboard (goroutine)
+	CleanClipboard(m, password)
+	return password, nil
+}
+
+func (m *Menu) generateSelection() *ErrorDatabase {
+	// Prompt for policy selection, the default one first
+	policies := []string{m.Configuration.Generator.Policy}
+	for name := range m.Configuration.Generator.Policies {
+		if !strings.EqualFold(name, m.Configuration.Generator.Policy) {
+			policies = append(policies, name)
+		}
+	}
+	sort.Strings(policies[1:])
+	policy := policies[0]
+	if len(policies) > 1 {
+		i, err := PromptChoice(m, m.Configuration.Style.TextPolicy, policies)
+		if err.Cancelled || err.Error != nil || i < 0 {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to select policy: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+		policy = policies[i]
+	}
+
+	password, errGenerate := m.generatePassword(policy)
+	if errGenerate != nil {
+		return errGenerate
+	}
+
+	// Prompt to save the password, cancel to do not save it
+	const NewEntry = "Save into a new entry"
+	const ExistingEntry = "Save into an existing entry"
+	i, err := PromptChoice(m, m.Configuration.Style.TextMenu, []string{NewEntry, ExistingEntry})
+	if err.Cancelled || err.Error != nil || i < 0 {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select menu item: %s", err.Error, false)
+		}
+		return nil
+	}
+	if i == 0 {
+		return m.addEntry(password)
+	}
+
+	// Prompt for entry selection
+	selectedEntry, err := PromptEntries(m)
+	if err.Cancelled {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select entry: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+	return m.updateEntryField(selectedEntry, "Password", password)
+}
+
+// editEntry prompts for a field of the entry and its new value, typed or generated
+func (m *Menu) editEntry(entry *Entry) *ErrorDatabase {
+	// Prompt for field selection, a new field can be written
+	var fields []string
+	for _, v := range entry.FullEntry.Values {
+		fields = append(fields, v.Key)
+	}
+	field, err := PromptInputChoice(m, m.Configuration.Style.TextField, fields)
+	if err.Cancelled || err.Error != nil {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select field: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+	if field == "" {
+		return NewErrorDatabase("the field name can't be empty", nil, false)
+	}
+
+	// Prompt for value, typed or generated
+	const TypeValue = "Type a new value"
+	const GenerateValue = "Generate a new value"
+	i, err := PromptChoice(m, m.Configuration.Style.TextMenu, []string{TypeValue, GenerateValue})
+	if err.Cancelled || err.Error != nil || i < 0 {
+		if err.Error != nil {
+			return NewErrorDatabase("failed to select menu item: %s", err.Error, false)
+		}
+		// Cancelled
+		return NewErrorDatabase("", nil, false)
+	}
+
+	var value string
+	if i == 1 {
+		var errGenerate *ErrorDatabase
+		if value, errGenerate = m.generatePassword(""); errGenerate != nil {
+			return errGenerate
+		}
+	} else {
+		vd := entry.FullEntry.Get(field)
+		if field == "Password" || (vd != nil && vd.Value.Protected.Bool) {
+			value, err = PromptSecret(m, field)
+		} else {
+			value, err = PromptInput(m, field)
+		}
+		if err.Cancelled || err.Error != nil {
+			if err.Error != nil {
+				return NewErrorDatabase("failed to get field: %s", err.Error, false)
+			}
+			// Cancelled
+			return NewErrorDatabase("", nil, false)
+		}
+	}
+	return m.updateEntryField(entry, field, value)
+}
+
+// updateEntryField sets the value of an entry field, keeping the previous version into the history,
+// and saves the database
+func (m *Menu) updateEntryField(entry *Entry, field string, value string) *ErrorDatabase {
+	return m.saveEntryField(entry, field, value, true)
+}
+
+// saveEntryField sets the field of the entry and saves the database,
+// the previous version is pushed into the history only if asked
+func (m *Menu) saveEntryField(entry *Entry, field string, value string, history bool) *ErrorDatabase {
+	if entry == nil || entry.FullEntry.UUID.Compare(gokeepasslib.UUID{}) {
+		// Entry not found
+		return NewErrorDatabase("selected entry not found", nil, false)
+	}
+
+	// Copy the values, to do not change the previous version
+	updated := entry.FullEntry
+	updated.Values = append([]gokeepasslib.ValueData{}, updated.Values...)
+	protected := field == "Password" || field == OTP
+	if vd := updated.Get(field); vd != nil {
+		protected = protected || vd.Value.Protected.Bool
+	}
+	SetEntryValue(&updated, field, value, protected)
+	if history {
+		m.Database.PushHistory(&updated, entry.FullEntry)
+	}
+
+	previous, err := m.Database.UpdateEntry(updated)
+	if err != nil {
+		return NewErrorDatabase("failed to update entry: %s", err, false)
+	}
+	if err := m.Database.SaveDatabase(m.Configuration); err != nil {
+		// Restore the entry, it's not saved
+		m.Database.UpdateEntry(previous)
+		return NewErrorDatabase("failed to save database: %s", err, false)
+	}
+	log.Printf("updated field %s of entry %s", field, updated.GetTitle())
+	return nil
+}
+
+// entryOTP generates the OTP of the entry,
+// the HOTP counter is incremented and saved before the code is used
+func (m *Menu) entryOTP(entry *Entry) (string, *ErrorDatabase) {
+	return m.entryOTPAt(entry, m.now().Unix())
+}
+
+// selectedOTP generates the OTP chosen from the menu, a TOTP code expiring within
+// the threshold is replaced by the next one, waiting for it if configured
+func (m *Menu) selectedOTP(entry *Entry) (string, *ErrorDatabase) {
+	start := m.now()
+	now := start.Unix()
+	otpa, err := CreateOTPAuth(entry.FullEntry)
+	if err == nil && otpa.Type != HOTP {
+		if remaining := otpa.Remaining(now); remaining > 0 && remaining < m.Configuration.General.OTPThreshold {
+			if m.Configuration.General.OTPWait {
+				log.Printf("waiting %ds for the next otp", remaining)
+				m.sleep(time.Unix(now+int64(remaining), 0).Sub(start))
+			}
+			now += int64(remaining)
+		}
+	}
+	return m.entryOTPAt(entry, now)
+}
+
+// entryOTPAt generates the OTP of the entry at the given time
+func (m *Menu) entryOTPAt(entry *Entry, time int64) (string, *ErrorDatabase) {
+	otp, err := CreateOTP(entry.FullEntry, time)
+	if err != nil {
+		return "", NewErrorDatabase("failed to create otp: %s", err, false)
+	}
+	if err := m.advanceHOTPCounter(entry); err != nil {
+		return "", err
+	}
+	return otp, nil
+}
+
+// advanceHOTPCounter increments the HOTP counter of the entry and saves the database, TOTP entries are not changed.
+// As KeePassXC does, the counter is updated without pushing a history version
+func (m *Menu) advanceHOTPCounter(entry *Entry) *ErrorDatabase {
+	otpa, err := CreateOTPAuth(entry.FullEntry)
+	if err != nil || otpa.Type != HOTP {
+		return nil
+	}
+	field, value, err := otpa.NextCounter(entry.FullEntry)
+	if err != nil {
+		return NewErrorDatabase("failed to increment hotp counter: %s", err, false)
+	}
+	return m.saveEntryField(entry, field, value, false)
+}
+
+// getEntryValue prints the value of the entry field given to the get command, without prompts
+func (m *Menu) getEntryValue() *ErrorDatabase {
+	flags := m.Configuration.Flags
+	lookup := EntryLookup{UUID: flags.UUID, URL: flags.URL}
+	field := flags.Field
+	switch args := flags.CommandArgs; len(args) {
+	case 2:
+		if field != "" {
+			return NewErrorDatabase("the field is given twice", nil, false)
+		}
+		field = args[1]
+		fallthrough
+	case 1:
+		lookup.Query = args[0]
+	case 0:
+	default:
+		return NewErrorDatabase("too many arguments, usage: kpmenu get [entry] [field]", nil, false)
+	}
+	if field == "" {
+		field = "Password"
+	}
+
+	entry, err := m.Database.FindEntry(lookup)
+	if err != nil {
+		return NewErrorDatabase("%s", err, false)
+	}
+
+	var value string
+	if flags.OTP {
+		var errOTP *ErrorDatabase
+		if value, errOTP = m.entryOTP(entry); errOTP != nil {
+			return errOTP
+		}
+	} else {
+		v := entry.FullEntry.Get(field)
+		if v == nil {
+			return NewErrorDatabase(fmt.Sprintf("field %s not found in entry %s", field, entry.FullPath()), nil, false)
+		}
+		value = m.Database.EntryValue(entry, field)
+	}
+	log.Printf("printed a value of entry %s", entry.FullPath())
+	_, err = fmt.Fprintln(m.Output, value)
+	if err != nil {
+		return NewErrorDatabase("failed to print value: %s", err, false)
+	}
+	return nil
+}
+
+// listEntries prints the entries matching the list or search command, without prompts
+func (m *Menu) listEntries() *ErrorDatabase {
+	flags := m.Configuration.Flags
+	filter := EntryFilter{Group: flags.Group, Tag: flags.Tag, Expired: flags.Expired}
+	if flags.Command == CommandSearch {
+		if len(flags.CommandArgs) != 1 {
+			return NewErrorDatabase("usage: kpmenu search <query>", nil, false)
+		}
+		filter.Query = flags.CommandArgs[0]
+	} else if len(flags.CommandArgs) > 0 {
+		return NewErrorDatabase("too many arguments, usage: kpmenu list", nil, false)
+	}
+	entries := m.Database.ListEntries(filter)
+
+	var output string
+	if flags.JSON {
+		infos := make([]EntryInfo, 0, len(entries))
+		for _, e := range entries {
+			if flags.Secrets {
+				// Secret values are printed with their placeholders resolved
+				resolved := *e
+				resolved.FullEntry = m.Database.ResolvedEntry(e)
+				e = &resolved
+			}
+			infos = append(infos, e.Info(flags.Secrets))
+		}
+		data, err := json.Marshal(infos)
+		if err != nil {
+			return NewErrorDatabase("failed to encode entries: %s", err, false)
+		}
+		output = string(data)
+	} else {
+		var lines []string
+		for _, e := range entries {
+			line := e.FullPath()
+			if flags.Secrets {
+				line += "\t" + m.Database.EntryValue(e, "Password")
+			}
+			lines = append(lines, line)
+		}
+		output = strings.Join(lines, "\n")
+	}
+	log.Printf("listed %d entries", len(entries))
+	if output == "" {
+		return nil
+	}
+	if _, err := fmt.Fprintln(m.Output, output); err != nil {
+		return NewErrorDatabase("failed to print entries: %s", err, false)
+	}
+	return nil
+}
+
 // ErrorDatabase is an error that can be fatal or non-fatal
 type ErrorDatabase struct {
 	Message       string
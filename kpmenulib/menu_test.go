@@ -0,0 +1,238 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+func TestGetEntryValue(t *testing.T) {
+	m := NewMenu()
+	m.Database = newTestDatabaseGroups(
+		newTestGroup("Web",
+			newTestEntry("Title", "Mail", "UserName", "alice", "Password", "mailpw", "URL", "https://mail.example.com", "Notes", "{USERNAME}@mail"),
+			newTestEntry("Title", "Forum", "UserName", "bob", "Password", "forumpw"),
+		),
+		newTestGroup("Work", newTestEntry("Title", "Mail", "UserName", "carol", "Password", "workpw")),
+	)
+	workMail := m.Database.Entries[2].HexUUID()
+
+	tests := []struct {
+		name  string
+		flags Flags
+		want  string
+	}{
+		{"path", Flags{CommandArgs: []string{"Web/Mail"}}, "mailpw"},
+		{"title", Flags{CommandArgs: []string{"Forum"}}, "forumpw"},
+		{"field argument", Flags{CommandArgs: []string{"Forum", "UserName"}}, "bob"},
+		{"field flag", Flags{CommandArgs: []string{"Forum"}, Field: "UserName"}, "bob"},
+		{"URL", Flags{URL: "https://mail.example.com/inbox"}, "mailpw"},
+		{"UUID", Flags{UUID: workMail}, "workpw"},
+		{"UUID and title", Flags{CommandArgs: []string{"Mail"}, UUID: workMail}, "workpw"},
+		{"resolved placeholders", Flags{CommandArgs: []string{"Web/Mail", "Notes"}}, "alice@mail"},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		m.Output = &output
+		m.Configuration.Flags = tt.flags
+		if err := m.getEntryValue(); err != nil {
+			t.Errorf("%s: getEntryValue() failed: %s", tt.name, err)
+		} else if output.String() != tt.want+"\n" {
+			t.Errorf("%s: getEntryValue() printed %q, want %q", tt.name, output.String(), tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		name  string
+		flags Flags
+		want  string
+	}{
+		{"nothing given", Flags{}, "no entry given, use a path, title, UUID or URL"},
+		{"not found", Flags{CommandArgs: []string{"Bank"}}, "no entry found"},
+		{"field not found", Flags{CommandArgs: []string{"Forum", "URL"}}, "field URL not found in entry Web/Forum"},
+		{"field given twice", Flags{CommandArgs: []string{"Forum", "UserName"}, Field: "Password"}, "the field is given twice"},
+		{"too many arguments", Flags{CommandArgs: []string{"Forum", "UserName", "Password"}}, "too many arguments, usage: kpmenu get [entry] [field]"},
+		{"ambiguous", Flags{CommandArgs: []string{"Mail"}}, "the entry is ambiguous, 2 entries match: Web/Mail (" + m.Database.Entries[0].HexUUID() + "), Work/Mail (" + workMail + ")"},
+	}
+	for _, tt := range errorTests {
+		var output bytes.Buffer
+		m.Output = &output
+		m.Configuration.Flags = tt.flags
+		if err := m.getEntryValue(); err == nil || err.String() != tt.want {
+			t.Errorf("%s: getEntryValue() = %v, want the error %q", tt.name, err, tt.want)
+		}
+		if output.Len() > 0 {
+			t.Errorf("%s: getEntryValue() printed %q", tt.name, output.String())
+		}
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	mail := newTestEntry("Title", "Mail", "UserName", "alice", "Password", "mailpw", "URL", "https://mail.example.com", "Notes", "{USERNAME}@mail")
+	mail.Tags = "Personal; Email"
+	forum := newTestEntry("Title", "Forum", "UserName", "bob", "Password", "forumpw")
+	forum.Tags = "personal,forum"
+	expired := newTestEntry("Title", "Old", "Password", "oldpw")
+	expiry := w.Now()
+	expiry.Time = time.Now().Add(-time.Hour)
+	expired.Times.Expires = w.NewBoolWrapper(true)
+	expired.Times.ExpiryTime = &expiry
+	web := newTestGroup("Web", mail, forum)
+	web.Groups = append(web.Groups, newTestGroup("Archive", expired))
+
+	m := NewMenu()
+	m.Database = newTestDatabaseGroups(web, newTestGroup("Work", newTestEntry("Title", "Bank", "UserName", "carol", "Password", "bankpw")))
+
+	list := func(flags Flags) string {
+		var output bytes.Buffer
+		m.Output = &output
+		m.Configuration.Flags = flags
+		if err := m.listEntries(); err != nil {
+			t.Fatalf("listEntries(%+v) failed: %s", flags, err)
+		}
+		return output.String()
+	}
+
+	// Filters
+	tests := []struct {
+		name  string
+		flags Flags
+		want  string
+	}{
+		{"list", Flags{Command: CommandList}, "Web/Mail\nWeb/Forum\nWeb/Archive/Old\nWork/Bank\n"},
+		{"group with subgroups", Flags{Command: CommandList, Group: "/Web/"}, "Web/Mail\nWeb/Forum\nWeb/Archive/Old\n"},
+		{"subgroup", Flags{Command: CommandList, Group: "Web/Archive"}, "Web/Archive/Old\n"},
+		{"group prefix", Flags{Command: CommandList, Group: "We"}, ""},
+		{"tag", Flags{Command: CommandList, Tag: "PERSONAL"}, "Web/Mail\nWeb/Forum\n"},
+		{"tag and group", Flags{Command: CommandList, Tag: "email", Group: "Web"}, "Web/Mail\n"},
+		{"expired", Flags{Command: CommandList, Expired: true}, "Web/Archive/Old\n"},
+		{"search", Flags{Command: CommandSearch, CommandArgs: []string{"CAROL"}}, "Work/Bank\n"},
+		{"search tag", Flags{Command: CommandSearch, CommandArgs: []string{"forum"}}, "Web/Forum\n"},
+	}
+	for _, tt := range tests {
+		if got := list(tt.flags); got != tt.want {
+			t.Errorf("%s: listEntries() printed %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// JSON shape
+	var infos []map[string]interface{}
+	if err := json.Unmarshal([]byte(list(Flags{Command: CommandList, JSON: true, Tag: "email"})), &infos); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"uuid":     m.Database.Entries[0].HexUUID(),
+		"title":    "Mail",
+		"username": "alice",
+		"url":      "https://mail.example.com",
+		"group":    "Web",
+		"tags":     []interface{}{"Personal", "Email"},
+		"fields":   []interface{}{"Notes", "Password", "Title", "URL", "UserName"},
+		"expired":  false,
+	}
+	if len(infos) != 1 || !reflect.DeepEqual(infos[0], want) {
+		t.Errorf("listEntries() with JSON = %v, want %v", infos, want)
+	}
+	if got := list(Flags{Command: CommandList, JSON: true, Tag: "unknown"}); got != "[]\n" {
+		t.Errorf("listEntries() with JSON and no entries printed %q, want []", got)
+	}
+
+	// Secret values are printed only if asked
+	for _, flags := range []Flags{{Command: CommandList}, {Command: CommandList, JSON: true}, {Command: CommandSearch, CommandArgs: []string{"mail"}, JSON: true}} {
+		if got := list(flags); strings.Contains(got, "pw") || strings.Contains(got, "alice@mail") {
+			t.Errorf("listEntries(%+v) printed secret values: %q", flags, got)
+		}
+	}
+	if got := list(Flags{Command: CommandList, Secrets: true, Group: "Work"}); got != "Work/Bank\tbankpw\n" {
+		t.Errorf("listEntries() with secrets printed %q, want the password", got)
+	}
+	infos = nil
+	if err := json.Unmarshal([]byte(list(Flags{Command: CommandList, JSON: true, Secrets: true, Tag: "email"})), &infos); err != nil {
+		t.Fatal(err)
+	}
+	wantValues := map[string]interface{}{"Title": "Mail", "UserName": "alice", "Password": "mailpw", "URL": "https://mail.example.com", "Notes": "alice@mail"}
+	if len(infos) != 1 || !reflect.DeepEqual(infos[0]["values"], wantValues) {
+		t.Errorf("listEntries() with JSON and secrets = %v, want the values %v", infos, wantValues)
+	}
+}
+
+func TestAddOTP(t *testing.T) {
+	uri := "otpauth://totp/Example:alice?secret=" + rfcSecretSHA1 + "&issuer=Example"
+	previousSecret := base32.StdEncoding.EncodeToString([]byte("abcdefghijabcdefghij"))
+	qrCode := writeTestImage(t, newTestQRCode(t, uri))
+	notOTPQRCode := writeTestImage(t, newTestQRCode(t, "https://example.com"))
+
+	tests := []struct {
+		name    string
+		answers []string
+		paste   string // Clipboard content
+		want    string // Saved otp field, empty if not saved
+		wantErr string
+	}{
+		{"secret", []string{"Type otpauth URI or secret", " gezd gnbv gy3t qojq GEZD GNBV GY3T QOJQ "}, "",
+			"otpauth://totp/Mail:alice?digits=6&issuer=Mail&period=30&secret=" + rfcSecretSHA1, ""},
+		{"typed uri", []string{"Type otpauth URI or secret", uri + "\n"}, "", uri, ""},
+		{"pasted uri", []string{"Paste otpauth URI from clipboard"}, uri, uri, ""},
+		{"qr code", []string{"Read QR code image", qrCode}, "", uri, ""},
+		{"invalid secret", []string{"Type otpauth URI or secret", "not a secret!"}, "", "", "failed to add otp: "},
+		{"invalid uri", []string{"Type otpauth URI or secret", "otpauth://totp/alice?secret=1"}, "", "", "failed to add otp: "},
+		{"pasted text", []string{"Paste otpauth URI from clipboard"}, "hello", "", "not an otpauth uri"},
+		{"qr code of another uri", []string{"Read QR code image", notOTPQRCode}, "", "", "not an otpauth uri"},
+		{"missing image", []string{"Read QR code image", qrCode + ".missing"}, "", "", "failed to decode qr code: "},
+		{"cancelled", nil, "", "", ""},
+	}
+	for _, tt := range tests {
+		m := NewMenu()
+		m.Configuration = newTestConfiguration(t)
+		m.Configuration.General.ClipboardTool = ClipboardToolCustom
+		m.Configuration.Executable.CustomClipboardPaste = "printf %s '" + tt.paste + "'"
+		m.Database = newTestDatabase(newTestEntry("Title", "Mail", "UserName", "alice", TimeOtpPrefix+"Secret-Base32", previousSecret))
+		m.Database.Keepass.Content.Meta.HistoryMaxItems = 10
+		m.Database.AddCredentialsToDatabase(m.Configuration, "password")
+		writeTestDatabase(t, m.Configuration, m.Database)
+		m.Prompter = &fakePrompter{answers: tt.answers}
+
+		err := m.addOTP(testEntry(t, m.Database, "Mail"))
+		e := testEntry(t, m.Database, "Mail")
+		if tt.want == "" {
+			if err == nil || !strings.HasPrefix(err.String(), tt.wantErr) {
+				t.Errorf("%s: addOTP() = %v, want the error %q", tt.name, err, tt.wantErr)
+			}
+			if e.FullEntry.Get(OTP) != nil || len(e.FullEntry.Histories) > 0 {
+				t.Errorf("%s: the entry is changed", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: addOTP() failed: %s", tt.name, err)
+			continue
+		}
+
+		// The otp field is used instead of the previous KeePass fields, kept unchanged
+		if got := e.FullEntry.GetContent(OTP); got != tt.want {
+			t.Errorf("%s: otp field %q, want %q", tt.name, got, tt.want)
+		}
+		if !e.FullEntry.Get(OTP).Value.Protected.Bool {
+			t.Errorf("%s: the otp field is not protected", tt.name)
+		}
+		if got := e.FullEntry.GetContent(TimeOtpPrefix + "Secret-Base32"); got != previousSecret {
+			t.Errorf("%s: TimeOtp secret %q, want %q", tt.name, got, previousSecret)
+		}
+		if code, err := CreateOTP(e.FullEntry, 59); err != nil || code != "287082" {
+			t.Errorf("%s: CreateOTP() = %s, %v, want 287082", tt.name, code, err)
+		}
+
+		// The entry without OTP is kept into the history
+		if len(e.FullEntry.Histories) != 1 || len(e.FullEntry.Histories[0].Entries) != 1 {
+			t.Errorf("%s: history %+v, want one version", tt.name, e.FullEntry.Histories)
+		} else if e.FullEntry.Histories[0].Entries[0].Get(OTP) != nil {
+			t.Errorf("%s: the history version has the otp field", tt.name)
+		}
+	}
+}
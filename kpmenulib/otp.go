@@ -3,15 +3,21 @@ package kpmenulib
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"math"
 	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/tobischo/gokeepasslib/v3"
 )
@@ -22,18 +28,45 @@ const (
 	TOTPSEED     = "TOTP Seed"
 	TOTPSETTINGS = "TOTP Settings"
 	TOTP         = "totp"
+	HOTP         = "hotp"
 	OTPAUTH      = "otpauth"
+	SteamEncoder = "steam"
 )
 
-// OTPAuth supports only TOTP (at the moment)
+// Prefixes of the OTP fields of KeePass 2.47+, TimeOtp-* for TOTP and HmacOtp-* for HOTP
+const (
+	TimeOtpPrefix = "TimeOtp-"
+	HmacOtpPrefix = "HmacOtp-"
+)
+
+// steamAlphabet is the alphabet of Steam Guard codes
+const steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+
+// Default settings of OTPs, used when not given
+const (
+	DefaultOTPPeriod = 30
+	DefaultOTPDigits = 6
+)
+
+// Range of the OTP digits, codes longer than 10 digits can't be generated from the 31 bits of the HMAC
+const (
+	minOTPDigits = 4
+	maxOTPDigits = 10
+)
+
+// OTPAuth supports TOTP and HOTP
 type OTPAuth struct {
-	secret  []byte
-	Type    string
-	Account string
-	Issuer  string
-	Period  int
-	Digits  int
-	err     error
+	secret    []byte
+	Type      string
+	Account   string
+	Issuer    string
+	Period    int
+	Digits    int
+	Algorithm string // SHA1, SHA256 or SHA512, SHA1 if empty
+	Counter   uint64 // Counter of HOTP
+	T0        int64  // Unix time the TOTP periods start from, 0 as per RFC 6238
+	Encoder   string // Encoder of the code, digits if empty or steam
+	err       error
 }
 
 // OTPError is a structure that handle an error of otp
@@ -67,16 +100,25 @@ func CreateOTP(a gokeepasslib.Entry, time int64) (otp string, err error) {
 	return otpa.Create(time)
 }
 
+// Create generates the code at the given time, HOTP uses its counter instead
 func (o OTPAuth) Create(time int64) (otp string, err error) {
-	// Default T0 as per RFC 6238 is 0
-	const T0 = 0
-	m := getMessage(time, T0, o.Period)
+	if err := o.validate(); err != nil {
+		return otp, err
+	}
+	var m []byte
+	if o.Type == HOTP {
+		m = make([]byte, 8)
+		binary.BigEndian.PutUint64(m, o.Counter)
+	} else {
+		m = getMessage(time, o.T0, o.Period)
+	}
 
-	key, err := base32.StdEncoding.DecodeString(string(o.secret))
+	key, err := decodeSecret(string(o.secret))
 	if err != nil {
 		return otp, fmt.Errorf("invalid key: %v", err)
 	}
-	hasher := hmac.New(sha1.New, key)
+	newHash, _ := getHash(o.Algorithm)
+	hasher := hmac.New(newHash, key)
 	_, err = hasher.Write(m)
 	if err != nil {
 		return otp, fmt.Errorf("failed create hash: %v", err)
@@ -89,7 +131,17 @@ func (o OTPAuth) Create(time int64) (otp string, err error) {
 		int32(h[ofs+2])<<8 |
 		int32(h[ofs+3])
 
-	otp = fmt.Sprint(r % int32(pow(10, o.Digits)))
+	if o.Encoder == SteamEncoder {
+		// Steam Guard codes are 5 characters of its alphabet
+		code := make([]byte, 5)
+		for i := range code {
+			code[i] = steamAlphabet[r%int32(len(steamAlphabet))]
+			r /= int32(len(steamAlphabet))
+		}
+		return string(code), nil
+	}
+
+	otp = fmt.Sprint(int64(r) % int64(pow(10, o.Digits)))
 	if len(otp) != o.Digits {
 		rpt := strings.Repeat("0", o.Digits-len(otp))
 		otp = rpt + otp
@@ -98,17 +150,75 @@ func (o OTPAuth) Create(time int64) (otp string, err error) {
 	return otp, nil
 }
 
-// getMessage constructs the message for HMAC with given params
-func getMessage(t1 int64, t0, stepTime int) (message []byte) {
-	if stepTime == 0 {
-		return []byte("ERROR_ZERO_STEPTIME")
+// Remaining returns the seconds the TOTP code of the given time is still valid, 0 for HOTP
+func (o OTPAuth) Remaining(time int64) int {
+	if o.Type == HOTP || o.Period <= 0 {
+		return 0
+	}
+	elapsed := (time - o.T0) % int64(o.Period)
+	if elapsed < 0 {
+		// Times before T0 are kept within the period
+		elapsed += int64(o.Period)
+	}
+	return o.Period - int(elapsed)
+}
+
+// validate checks the settings, with an error explaining the wrong one
+func (o OTPAuth) validate() error {
+	if o.Type != TOTP && o.Type != HOTP {
+		return fmt.Errorf("unsupported type %q", o.Type)
+	}
+	if len(o.secret) == 0 {
+		return errors.New("missing secret")
 	}
-	step := (t1 - int64(t0)) / int64(stepTime)
+	if _, err := decodeSecret(string(o.secret)); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	if o.Encoder != SteamEncoder && (o.Digits < minOTPDigits || o.Digits > maxOTPDigits) {
+		return fmt.Errorf("invalid digits %d, must be between %d and %d", o.Digits, minOTPDigits, maxOTPDigits)
+	}
+	if o.Type == TOTP && o.Period <= 0 {
+		return fmt.Errorf("invalid period %d, must be positive", o.Period)
+	}
+	if o.T0 < 0 {
+		return fmt.Errorf("invalid t0 %d, must not be negative", o.T0)
+	}
+	_, err := getHash(o.Algorithm)
+	return err
+}
+
+// decodeSecret decodes a base32 secret, ignoring case, whitespace and padding
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, secret)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// getMessage constructs the message for HMAC with given params
+func getMessage(t1 int64, t0 int64, stepTime int) (message []byte) {
+	step := (t1 - t0) / int64(stepTime)
 	message = make([]byte, 8)
 	binary.BigEndian.PutUint64(message, uint64(step))
 	return message
 }
 
+// getHash returns the hash function of the HMAC algorithm
+func getHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+}
+
 // pow returns x^y
 func pow(x, y int) int {
 	return int(math.Pow(float64(x), float64(y)))
@@ -120,21 +230,64 @@ func getOffset(hash []byte) int {
 	return int(lastByte & 0xf)
 }
 
+// HasOTP checks if the entry has the secret of an OTP, in any supported format.
+// Settings without a secret, as a lone TimeOtp-Period, can't generate codes
+func HasOTP(a gokeepasslib.Entry) bool {
+	return a.Get(OTP) != nil || a.Get(TOTPSEED) != nil ||
+		keePassOTPSecretField(a, TimeOtpPrefix) != "" || keePassOTPSecretField(a, HmacOtpPrefix) != ""
+}
+
+// isOTPField checks if the field contains OTP settings
+func isOTPField(key string) bool {
+	return key == OTP || key == TOTPSEED || key == TOTPSETTINGS ||
+		strings.HasPrefix(key, TimeOtpPrefix) || strings.HasPrefix(key, HmacOtpPrefix)
+}
+
+// CreateOTPAuth reads the OTP settings of the entry. They are read in order from the `otp` field
+// (otpauth URL or KeeOtp settings), the KeePass TimeOtp-* and HmacOtp-* fields and the legacy TOTP fields.
+// Digits and period not given are 6 and 30
 func CreateOTPAuth(a gokeepasslib.Entry) (otp OTPAuth, err error) {
+	otp, err = readOTPAuth(a)
+	if err != nil {
+		return otp, err
+	}
+	if err := otp.validate(); err != nil {
+		return OTPAuth{}, OTPError{err: err}
+	}
+	return otp, nil
+}
+
+// readOTPAuth reads the OTP settings of the entry without validating them
+func readOTPAuth(a gokeepasslib.Entry) (otp OTPAuth, err error) {
+	if vd := a.Get(OTP); vd != nil {
+		value := strings.TrimSpace(vd.Value.Content)
+		if strings.Contains(value, "://") || !strings.Contains(value, "key=") {
+			otp, err = parseOTPAuth(value)
+		} else {
+			otp, err = parseKeeOTP(value)
+		}
+		if err != nil {
+			return OTPAuth{}, OTPError{err: err}
+		}
+		return otp, nil
+	}
+
+	for _, prefix := range []string{TimeOtpPrefix, HmacOtpPrefix} {
+		if keePassOTPSecretField(a, prefix) != "" {
+			otp, err = parseKeePassOTP(a, prefix)
+			if err != nil {
+				return OTPAuth{}, OTPError{err: err}
+			}
+			return otp, nil
+		}
+	}
+
+	otp = OTPAuth{Type: TOTP, Period: DefaultOTPPeriod, Digits: DefaultOTPDigits}
 	for _, vd := range a.Values {
 		switch vd.Key {
 		default:
 			// Nothing
-		case OTP:
-			otp, err = parseOTPAuth(strings.TrimSpace(vd.Value.Content))
-			if err != nil {
-				return OTPAuth{}, OTPError{
-					err: fmt.Errorf("invalid key: %v", err),
-				}
-			}
-			return otp, nil
 		case TOTPSEED:
-			otp.Type = TOTP
 			otp.secret = []byte(strings.TrimSpace(vd.Value.Content))
 		case TOTPSETTINGS:
 			parts := strings.Split(strings.TrimSpace(vd.Value.Content), ";")
@@ -150,6 +303,12 @@ func CreateOTPAuth(a gokeepasslib.Entry) (otp OTPAuth, err error) {
 				}
 			}
 			otp.Period = refresh
+			if parts[1] == "S" {
+				// KeeTrayTOTP Steam settings
+				otp.Encoder = SteamEncoder
+				otp.Digits = 5
+				continue
+			}
 			digits, err := strconv.Atoi(parts[1])
 			if err != nil {
 				return otp, OTPError{
@@ -162,6 +321,116 @@ func CreateOTPAuth(a gokeepasslib.Entry) (otp OTPAuth, err error) {
 	return otp, nil
 }
 
+// keePassOTPSecretField returns the field containing the KeePass OTP secret with the prefix, empty if not found.
+// The secret is encoded as UTF-8, hex, base32 or base64 depending on the field
+func keePassOTPSecretField(a gokeepasslib.Entry, prefix string) string {
+	for _, suffix := range []string{"Secret", "Secret-Hex", "Secret-Base32", "Secret-Base64"} {
+		if a.GetContent(prefix+suffix) != "" {
+			return prefix + suffix
+		}
+	}
+	return ""
+}
+
+// parseKeePassOTP parses the OTP fields of KeePass 2.47+ with the prefix
+func parseKeePassOTP(a gokeepasslib.Entry, prefix string) (OTPAuth, error) {
+	otp := OTPAuth{Type: TOTP, Period: DefaultOTPPeriod, Digits: DefaultOTPDigits}
+	if prefix == HmacOtpPrefix {
+		otp.Type = HOTP
+	}
+
+	field := keePassOTPSecretField(a, prefix)
+	value := strings.TrimSpace(a.GetContent(field))
+	var secret []byte
+	var err error
+	switch strings.TrimPrefix(field, prefix) {
+	case "Secret":
+		secret = []byte(value)
+	case "Secret-Hex":
+		secret, err = hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+	case "Secret-Base32":
+		secret, err = decodeSecret(value)
+	case "Secret-Base64":
+		secret, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil {
+		return otp, fmt.Errorf("invalid key of %s: %v", field, err)
+	}
+	otp.secret = []byte(base32.StdEncoding.EncodeToString(secret))
+
+	if v := strings.TrimSpace(a.GetContent(prefix + "Length")); v != "" {
+		if otp.Digits, err = strconv.Atoi(v); err != nil {
+			return otp, fmt.Errorf("invalid %sLength %s", prefix, v)
+		}
+	}
+	if v := strings.TrimSpace(a.GetContent(prefix + "Period")); v != "" && otp.Type == TOTP {
+		if otp.Period, err = strconv.Atoi(v); err != nil {
+			return otp, fmt.Errorf("invalid %sPeriod %s", prefix, v)
+		}
+	}
+	if v := strings.TrimSpace(a.GetContent(prefix + "Counter")); v != "" && otp.Type == HOTP {
+		if otp.Counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return otp, fmt.Errorf("invalid %sCounter %s", prefix, v)
+		}
+	}
+	if v := strings.TrimSpace(a.GetContent(prefix + "Algorithm")); v != "" {
+		// KeePass names algorithms as HMAC-SHA-256
+		otp.Algorithm = strings.ReplaceAll(strings.TrimPrefix(strings.ToUpper(v), "HMAC-"), "-", "")
+		if _, err := getHash(otp.Algorithm); err != nil {
+			return otp, err
+		}
+	}
+	return otp, nil
+}
+
+// parseKeeOTP parses the settings of KeeOtp, stored into the otp field as
+//
+//     key=SECRET&type=Totp&step=30&size=6&otpHashMode=Sha1&counter=0&encoder=steam
+//
+// where only the key is required
+func parseKeeOTP(s string) (OTPAuth, error) {
+	otp := OTPAuth{Type: TOTP, Period: DefaultOTPPeriod, Digits: DefaultOTPDigits}
+	query, err := url.ParseQuery(s)
+	if err != nil {
+		return otp, err
+	}
+	otp.secret = []byte(query.Get("key"))
+	if v := query.Get("type"); v != "" {
+		otp.Type = strings.ToLower(v)
+		if otp.Type != TOTP && otp.Type != HOTP {
+			return otp, fmt.Errorf("unsupported type %s", v)
+		}
+	}
+	if v := query.Get("step"); v != "" {
+		if otp.Period, err = strconv.Atoi(v); err != nil {
+			return otp, fmt.Errorf("invalid step %s", v)
+		}
+	}
+	if v := query.Get("size"); v != "" {
+		if otp.Digits, err = strconv.Atoi(v); err != nil {
+			return otp, fmt.Errorf("invalid size %s", v)
+		}
+	}
+	if v := query.Get("counter"); v != "" {
+		if otp.Counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return otp, fmt.Errorf("invalid counter %s", v)
+		}
+	}
+	if v := query.Get("otpHashMode"); v != "" {
+		otp.Algorithm = strings.ToUpper(v)
+		if _, err := getHash(otp.Algorithm); err != nil {
+			return otp, err
+		}
+	}
+	if v := query.Get("encoder"); v != "" {
+		if !strings.EqualFold(v, SteamEncoder) {
+			return otp, fmt.Errorf("unsupported encoder %s", v)
+		}
+		otp.Encoder = SteamEncoder
+	}
+	return otp, nil
+}
+
 // parseOTPAuth parses a Google Authenticator otpauth URL, which is used by
 // both KeepassXC and Keepass2Android.
 //
@@ -177,9 +446,11 @@ func CreateOTPAuth(a gokeepasslib.Entry) (otp OTPAuth, err error) {
 // in the generated TOTP code, commonly `6`; and ISSUER is the TOTP issuer, e.g.
 // `github`.
 //
-// The spec is at https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+// The spec is at https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+// The issuer parameter takes precedence over the issuer of the label, digits and period
+// default to 6 and 30, t0 (not in the spec) is the Unix time the periods start from
 func parseOTPAuth(s string) (OTPAuth, error) {
-	otp := OTPAuth{}
+	otp := OTPAuth{Period: DefaultOTPPeriod, Digits: DefaultOTPDigits}
 	u, err := url.ParseRequestURI(s)
 	if err != nil {
 		return otp, err
@@ -187,45 +458,103 @@ func parseOTPAuth(s string) (OTPAuth, error) {
 	if u.Scheme != OTPAUTH {
 		return otp, errors.New("invalid format; must start with otpauth://")
 	}
-	if u.Host != TOTP {
-		return otp, errors.New("only totp is supported")
+	otp.Type = strings.ToLower(u.Host)
+	if otp.Type != TOTP && otp.Type != HOTP {
+		return otp, errors.New("only totp and hotp are supported")
 	}
 	_, label := filepath.Split(u.Path)
-	otp.Type = label
 	parts := strings.Split(label, ":")
 	if len(parts) > 2 {
 		return otp, fmt.Errorf("invalid label %s", label)
 	}
 	if len(parts) == 1 {
-		otp.Account = parts[0]
+		otp.Account = strings.TrimSpace(parts[0])
 	} else {
-		otp.Issuer = parts[0]
-		otp.Account = parts[1]
+		otp.Issuer = strings.TrimSpace(parts[0])
+		otp.Account = strings.TrimSpace(parts[1])
 	}
 	ur, err := url.Parse(s)
 	if err != nil {
 		return otp, err
 	}
-	for k, vs := range ur.Query() {
+	query := ur.Query()
+	if otp.Type == HOTP && query.Get("counter") == "" {
+		return otp, errors.New("the counter is required by hotp")
+	}
+	for k, vs := range query {
 		if len(vs) != 1 {
 			return OTPAuth{}, OTPError{
-				err: fmt.Errorf("invalid key, too many parameter values for %s", k),
+				err: fmt.Errorf("too many values of parameter %s", k),
 			}
 		}
 		switch k {
 		case "secret":
 			otp.secret = []byte(vs[0])
+		case "issuer":
+			if vs[0] != "" {
+				otp.Issuer = vs[0]
+			}
 		case "digits":
 			otp.Digits, err = strconv.Atoi(vs[0])
 			if err != nil {
-				return otp, OTPError{err: err}
+				return otp, OTPError{err: fmt.Errorf("invalid digits %s", vs[0])}
 			}
 		case "period":
 			otp.Period, err = strconv.Atoi(vs[0])
 			if err != nil {
+				return otp, OTPError{err: fmt.Errorf("invalid period %s", vs[0])}
+			}
+		case "algorithm":
+			if _, err := getHash(vs[0]); err != nil {
 				return otp, OTPError{err: err}
 			}
+			otp.Algorithm = strings.ToUpper(vs[0])
+		case "counter":
+			otp.Counter, err = strconv.ParseUint(vs[0], 10, 64)
+			if err != nil {
+				return otp, OTPError{err: fmt.Errorf("invalid counter %s", vs[0])}
+			}
+		case "t0":
+			otp.T0, err = strconv.ParseInt(vs[0], 10, 64)
+			if err != nil {
+				return otp, OTPError{err: fmt.Errorf("invalid t0 %s", vs[0])}
+			}
+		case "encoder":
+			// KeePassXC Steam settings
+			if !strings.EqualFold(vs[0], SteamEncoder) {
+				return otp, OTPError{err: fmt.Errorf("unsupported encoder %s", vs[0])}
+			}
+			otp.Encoder = SteamEncoder
 		}
 	}
 	return otp, nil
 }
+
+// NextCounter returns the field of the entry storing the HOTP counter and its value with the counter incremented
+func (o OTPAuth) NextCounter(entry gokeepasslib.Entry) (field string, value string, err error) {
+	if o.Type != HOTP {
+		return "", "", errors.New("only hotp has a counter")
+	}
+	counter := strconv.FormatUint(o.Counter+1, 10)
+	otp := strings.TrimSpace(entry.GetContent(OTP))
+	switch {
+	case otp == "":
+		return HmacOtpPrefix + "Counter", counter, nil
+	case strings.Contains(otp, "://"):
+		u, err := url.Parse(otp)
+		if err != nil {
+			return "", "", err
+		}
+		query := u.Query()
+		query.Set("counter", counter)
+		u.RawQuery = query.Encode()
+		return OTP, u.String(), nil
+	}
+	// KeeOtp settings
+	query, err := url.ParseQuery(otp)
+	if err != nil {
+		return "", "", err
+	}
+	query.Set("counter", counter)
+	return OTP, query.Encode(), nil
+}
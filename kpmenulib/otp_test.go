@@ -0,0 +1,553 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// RFC 4226 and RFC 6238 test secrets, the SHA256 and SHA512 ones are repeated to the size of the hash
+var (
+	rfcSecretSHA1   = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	rfcSecretSHA256 = base32.StdEncoding.EncodeToString([]byte(strings.Repeat("1234567890", 4)[:32]))
+	rfcSecretSHA512 = base32.StdEncoding.EncodeToString([]byte(strings.Repeat("1234567890", 7)[:64]))
+)
+
+// createTestOTP creates the code of the entry with the otp field at the given time
+func createTestOTP(t *testing.T, otp string, time int64) string {
+	t.Helper()
+	code, err := CreateOTP(newTestEntry(OTP, otp), time)
+	if err != nil {
+		t.Fatalf("CreateOTP(%s) failed: %v", otp, err)
+	}
+	return code
+}
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	codes := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, want := range codes {
+		otp := fmt.Sprintf("otpauth://hotp/Example:alice?secret=%s&counter=%d", rfcSecretSHA1, counter)
+		if got := createTestOTP(t, otp, 0); got != want {
+			t.Errorf("HOTP with counter %d = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B
+	tests := []struct {
+		algorithm string
+		secret    string
+		time      int64
+		want      string
+	}{
+		{"SHA1", rfcSecretSHA1, 59, "94287082"},
+		{"SHA1", rfcSecretSHA1, 1111111109, "07081804"},
+		{"SHA1", rfcSecretSHA1, 1234567890, "89005924"},
+		{"SHA1", rfcSecretSHA1, 20000000000, "65353130"},
+		{"SHA256", rfcSecretSHA256, 59, "46119246"},
+		{"SHA256", rfcSecretSHA256, 1111111109, "68084774"},
+		{"SHA256", rfcSecretSHA256, 1234567890, "91819424"},
+		{"SHA256", rfcSecretSHA256, 20000000000, "77737706"},
+		{"SHA512", rfcSecretSHA512, 59, "90693936"},
+		{"SHA512", rfcSecretSHA512, 1111111109, "25091201"},
+		{"SHA512", rfcSecretSHA512, 1234567890, "93441116"},
+		{"SHA512", rfcSecretSHA512, 20000000000, "47863826"},
+	}
+	for _, tt := range tests {
+		otp := fmt.Sprintf("otpauth://totp/Example:alice?secret=%s&digits=8&algorithm=%s", tt.secret, tt.algorithm)
+		if got := createTestOTP(t, otp, tt.time); got != tt.want {
+			t.Errorf("TOTP %s at %d = %s, want %s", tt.algorithm, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestParseHOTP(t *testing.T) {
+	tests := []struct {
+		otp       string
+		counter   uint64
+		algorithm string
+	}{
+		{"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=7", 7, ""},
+		{"otpauth://HOTP/alice?secret=" + rfcSecretSHA1 + "&counter=18446744073709551615&algorithm=sha256", 18446744073709551615, "SHA256"},
+		{"key=" + rfcSecretSHA1 + "&type=Hotp&counter=3&otpHashMode=Sha512", 3, "SHA512"},
+	}
+	for _, tt := range tests {
+		otp, err := CreateOTPAuth(newTestEntry(OTP, tt.otp))
+		if err != nil {
+			t.Errorf("CreateOTPAuth(%s) failed: %v", tt.otp, err)
+			continue
+		}
+		if otp.Type != HOTP || otp.Counter != tt.counter || otp.Algorithm != tt.algorithm {
+			t.Errorf("CreateOTPAuth(%s) = type %s, counter %d, algorithm %q, want hotp, %d, %q",
+				tt.otp, otp.Type, otp.Counter, otp.Algorithm, tt.counter, tt.algorithm)
+		}
+	}
+
+	for _, invalid := range []string{
+		"otpauth://hotp/alice?secret=" + rfcSecretSHA1,                              // missing counter
+		"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=-1",              // negative counter
+		"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=x",               // invalid counter
+		"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=1&algorithm=MD5", // unsupported algorithm
+	} {
+		if _, err := CreateOTPAuth(newTestEntry(OTP, invalid)); err == nil {
+			t.Errorf("CreateOTPAuth(%s) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestNextCounter(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry []string
+		field string
+		value string
+	}{
+		{
+			"otpauth url",
+			[]string{OTP, "otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=9"},
+			OTP, "otpauth://hotp/alice?counter=10&secret=" + rfcSecretSHA1,
+		},
+		{
+			"keeotp",
+			[]string{OTP, "key=" + rfcSecretSHA1 + "&type=hotp&counter=1"},
+			OTP, "counter=2&key=" + rfcSecretSHA1 + "&type=hotp",
+		},
+		{
+			"keeotp without counter",
+			[]string{OTP, "key=" + rfcSecretSHA1 + "&type=hotp"},
+			OTP, "counter=1&key=" + rfcSecretSHA1 + "&type=hotp",
+		},
+		{
+			"keepass fields",
+			[]string{HmacOtpPrefix + "Secret", "12345678901234567890", HmacOtpPrefix + "Counter", "4"},
+			HmacOtpPrefix + "Counter", "5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := newTestEntry(tt.entry...)
+			otp, err := CreateOTPAuth(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			field, value, err := otp.NextCounter(entry)
+			if err != nil || field != tt.field || value != tt.value {
+				t.Errorf("NextCounter() = %s, %s, %v, want %s, %s", field, value, err, tt.field, tt.value)
+			}
+
+			// The settings read back have the incremented counter
+			SetEntryValue(&entry, field, value, false)
+			next, err := CreateOTPAuth(entry)
+			if err != nil || next.Counter != otp.Counter+1 {
+				t.Errorf("counter after NextCounter() = %d, %v, want %d", next.Counter, err, otp.Counter+1)
+			}
+		})
+	}
+
+	totp, err := CreateOTPAuth(newTestEntry(OTP, "otpauth://totp/alice?secret="+rfcSecretSHA1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := totp.NextCounter(newTestEntry()); err == nil {
+		t.Error("NextCounter() of totp succeeded, want an error")
+	}
+}
+
+func TestParseOTPAuthSecret(t *testing.T) {
+	spaced := strings.ToLower(rfcSecretSHA1[:8] + " " + rfcSecretSHA1[8:16] + "\t" + rfcSecretSHA1[16:])
+	for _, otp := range []string{
+		"otpauth://totp/alice?digits=8&secret=" + strings.ToLower(rfcSecretSHA1),
+		"otpauth://totp/alice?digits=8&secret=" + strings.ReplaceAll(spaced, "\t", "%09"),
+		"key=" + strings.ReplaceAll(spaced, " ", "+") + "&size=8",
+	} {
+		if got := createTestOTP(t, otp, 59); got != "94287082" {
+			t.Errorf("TOTP of %s = %s, want 94287082", otp, got)
+		}
+	}
+
+	// Secrets of a length not multiple of 5 bytes are padded
+	padded := base32.StdEncoding.EncodeToString([]byte("123456"))
+	want := createTestOTP(t, "otpauth://totp/alice?secret="+padded, 59)
+	for _, secret := range []string{strings.TrimRight(padded, "="), strings.ToLower(strings.TrimRight(padded, "="))} {
+		if got := createTestOTP(t, "otpauth://totp/alice?secret="+secret, 59); got != want {
+			t.Errorf("TOTP of secret %s = %s, want %s as %s", secret, got, want, padded)
+		}
+	}
+}
+
+func TestParseOTPAuthSettings(t *testing.T) {
+	totpURL := "otpauth://totp/alice?secret=" + rfcSecretSHA1
+	tests := []struct {
+		otp string
+		err string // Empty if valid
+	}{
+		{totpURL, ""},
+		{totpURL + "&period=1", ""},
+		{totpURL + "&period=0", "invalid period 0, must be positive"},
+		{totpURL + "&period=-30", "invalid period -30, must be positive"},
+		{totpURL + "&period=x", "invalid period x"},
+		{totpURL + "&digits=0", "invalid digits 0, must be between 4 and 10"},
+		{totpURL + "&digits=3", "invalid digits 3, must be between 4 and 10"},
+		{totpURL + "&digits=4", ""},
+		{totpURL + "&digits=10", ""},
+		{totpURL + "&digits=11", "invalid digits 11, must be between 4 and 10"},
+		{totpURL + "&digits=x", "invalid digits x"},
+		{totpURL + "&t0=-1", "invalid t0 -1, must not be negative"},
+		{totpURL + "&t0=x", "invalid t0 x"},
+		{totpURL + "&algorithm=MD5", "unsupported algorithm MD5"},
+		{totpURL + "&encoder=other", "unsupported encoder other"},
+		{totpURL + "&digits=6&digits=8", "too many values of parameter digits"},
+		{"otpauth://hotp/alice?secret=" + rfcSecretSHA1, "the counter is required by hotp"},
+		{"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=x", "invalid counter x"},
+		{"otpauth://totp/alice?secret=", "missing secret"},
+		{"otpauth://totp/alice?secret=1", "invalid key: illegal base32 data at input byte 0"},
+		{"otpauth://other/alice?secret=" + rfcSecretSHA1, "only totp and hotp are supported"},
+		{"key=" + rfcSecretSHA1 + "&step=0", "invalid period 0, must be positive"},
+		{"key=" + rfcSecretSHA1 + "&step=x", "invalid step x"},
+		{"key=" + rfcSecretSHA1 + "&size=11", "invalid digits 11, must be between 4 and 10"},
+		{"key=" + rfcSecretSHA1 + "&size=x", "invalid size x"},
+		{"key=" + rfcSecretSHA1 + "&type=hotp&counter=x", "invalid counter x"},
+	}
+	for _, tt := range tests {
+		_, err := CreateOTPAuth(newTestEntry(OTP, tt.otp))
+		if tt.err == "" && err != nil {
+			t.Errorf("CreateOTPAuth(%s) failed: %v", tt.otp, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("CreateOTPAuth(%s) error = %v, want %s", tt.otp, err, tt.err)
+		}
+	}
+
+	// Errors of the KeePass and legacy fields
+	fieldTests := []struct {
+		fields []string
+		err    string
+	}{
+		{[]string{TimeOtpPrefix + "Secret-Hex", "zz"}, "invalid key of TimeOtp-Secret-Hex: encoding/hex: invalid byte: U+007A 'z'"},
+		{[]string{TimeOtpPrefix + "Secret", "key", TimeOtpPrefix + "Length", "x"}, "invalid TimeOtp-Length x"},
+		{[]string{TimeOtpPrefix + "Secret", "key", TimeOtpPrefix + "Period", "0"}, "invalid period 0, must be positive"},
+		{[]string{HmacOtpPrefix + "Secret", "key", HmacOtpPrefix + "Counter", "x"}, "invalid HmacOtp-Counter x"},
+		{[]string{TOTPSEED, rfcSecretSHA1, TOTPSETTINGS, "30;3"}, "invalid digits 3, must be between 4 and 10"},
+		{[]string{TOTPSETTINGS, "30;6"}, "missing secret"},
+	}
+	for _, tt := range fieldTests {
+		_, err := CreateOTPAuth(newTestEntry(tt.fields...))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("CreateOTPAuth(%q) error = %v, want %s", tt.fields, err, tt.err)
+		}
+	}
+
+	if code := createTestOTP(t, totpURL+"&digits=10", 59); len(code) != 10 {
+		t.Errorf("TOTP with 10 digits = %s", code)
+	}
+}
+
+func TestParseOTPAuthIssuer(t *testing.T) {
+	tests := []struct {
+		otp     string
+		issuer  string
+		account string
+	}{
+		{"otpauth://totp/alice?secret=" + rfcSecretSHA1, "", "alice"},
+		{"otpauth://totp/Label:alice?secret=" + rfcSecretSHA1, "Label", "alice"},
+		{"otpauth://totp/Label:%20alice?secret=" + rfcSecretSHA1 + "&issuer=", "Label", "alice"},
+		{"otpauth://totp/Label:alice?secret=" + rfcSecretSHA1 + "&issuer=Param%20Issuer", "Param Issuer", "alice"},
+		{"otpauth://totp/alice?issuer=Param&secret=" + rfcSecretSHA1, "Param", "alice"},
+	}
+	for _, tt := range tests {
+		otp, err := CreateOTPAuth(newTestEntry(OTP, tt.otp))
+		if err != nil {
+			t.Errorf("CreateOTPAuth(%s) failed: %v", tt.otp, err)
+			continue
+		}
+		if otp.Issuer != tt.issuer || otp.Account != tt.account {
+			t.Errorf("CreateOTPAuth(%s) = issuer %q, account %q, want %q, %q", tt.otp, otp.Issuer, otp.Account, tt.issuer, tt.account)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	tests := []struct {
+		otp  OTPAuth
+		time int64
+		want int
+	}{
+		{OTPAuth{Type: TOTP, Period: 30}, 0, 30},
+		{OTPAuth{Type: TOTP, Period: 30}, 59, 1},
+		{OTPAuth{Type: TOTP, Period: 30}, 1000, 20},
+		{OTPAuth{Type: TOTP, Period: 30, T0: 100}, 1000, 30},
+		{OTPAuth{Type: TOTP, Period: 30, T0: 100}, 1001, 29},
+		{OTPAuth{Type: TOTP, Period: 60, T0: 10}, 69, 1},
+		{OTPAuth{Type: TOTP, Period: 0}, 1000, 0},
+		{OTPAuth{Type: HOTP, Period: 30}, 1000, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.otp.Remaining(tt.time); got != tt.want {
+			t.Errorf("Remaining(%d) with period %d and t0 %d = %d, want %d", tt.time, tt.otp.Period, tt.otp.T0, got, tt.want)
+		}
+	}
+
+	// The code changes when the remaining seconds end
+	otp := "otpauth://totp/alice?secret=" + rfcSecretSHA1 + "&t0=100"
+	if createTestOTP(t, otp, 1029) == createTestOTP(t, otp, 1030) {
+		t.Error("TOTP with t0 100 didn't change at 1030")
+	}
+	if createTestOTP(t, otp, 1000) != createTestOTP(t, otp, 1029) {
+		t.Error("TOTP with t0 100 changed before 1030")
+	}
+}
+
+func TestAdvanceHOTPCounter(t *testing.T) {
+	m := NewMenu()
+	m.Configuration.Database.Database = filepath.Join(t.TempDir(), "test.kdbx")
+	m.Database = newTestDatabase(newTestEntry("Title", "HOTP", OTP, "otpauth://hotp/alice?secret="+rfcSecretSHA1+"&counter=0"))
+	m.Database.Keepass.Credentials = gokeepasslib.NewPasswordCredentials("password")
+	if err := ioutil.WriteFile(m.Configuration.Database.Database, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"755224", "287082"} {
+		code, err := m.entryOTP(testEntry(t, m.Database, "HOTP"))
+		if err != nil {
+			t.Fatalf("entryOTP() failed: %s", err.String())
+		}
+		if code != want {
+			t.Errorf("entryOTP() = %s, want %s", code, want)
+		}
+	}
+	// The counter is saved without history versions
+	e := testEntry(t, m.Database, "HOTP")
+	if otp, _ := CreateOTPAuth(e.FullEntry); otp.Counter != 2 {
+		t.Errorf("counter = %d, want 2", otp.Counter)
+	}
+	if len(e.FullEntry.Histories) != 0 {
+		t.Errorf("%d history versions pushed, want none", len(e.FullEntry.Histories[0].Entries))
+	}
+
+	// The code isn't shown, it could be used without incrementing the counter
+	if preview := otpPreview(e.FullEntry, 0); preview != "" {
+		t.Errorf("otpPreview() of hotp = %q, want empty", preview)
+	}
+	if preview := otpPreview(newTestEntry(OTP, "otpauth://totp/alice?secret="+rfcSecretSHA1), 59); preview != "287 082, 1s" {
+		t.Errorf("otpPreview() of totp = %q, want 287 082, 1s", preview)
+	}
+}
+
+// fakeClock is the clock of the OTP codes, advanced only by sleeping
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+// setFakeClock sets the clock of the menu at the given time
+func setFakeClock(m *Menu, now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	m.now = func() time.Time { return c.now }
+	m.sleep = func(d time.Duration) {
+		c.slept += d
+		c.now = c.now.Add(d)
+	}
+	return c
+}
+
+func TestSelectedOTP(t *testing.T) {
+	otp := "otpauth://totp/alice?secret=" + rfcSecretSHA1
+	tests := []struct {
+		name      string
+		now       time.Time
+		threshold int
+		wait      bool
+		wantTime  int64 // Time of the code
+		wantSlept time.Duration
+	}{
+		{"above threshold", time.Unix(1030, 0), 5, true, 1030, 0},
+		{"at threshold", time.Unix(1045, 0), 5, true, 1045, 0},
+		{"below threshold", time.Unix(1046, 0), 5, false, 1050, 0},
+		{"below threshold waiting", time.Unix(1046, 0), 5, true, 1050, 4 * time.Second},
+		{"below threshold waiting with nanoseconds", time.Unix(1048, int64(500*time.Millisecond)), 5, true, 1050, 1500 * time.Millisecond},
+		{"last second", time.Unix(1049, 0), 5, true, 1050, time.Second},
+		{"threshold disabled", time.Unix(1049, 0), 0, true, 1049, 0},
+	}
+	for _, tt := range tests {
+		m := NewMenu()
+		m.Configuration.General.OTPThreshold = tt.threshold
+		m.Configuration.General.OTPWait = tt.wait
+		clock := setFakeClock(m, tt.now)
+
+		code, err := m.selectedOTP(&Entry{FullEntry: newTestEntry(OTP, otp)})
+		if err != nil {
+			t.Fatalf("%s: selectedOTP() failed: %s", tt.name, err.String())
+		}
+		if want := createTestOTP(t, otp, tt.wantTime); code != want {
+			t.Errorf("%s: selectedOTP() = %s, want the code at %d %s", tt.name, code, tt.wantTime, want)
+		}
+		if clock.slept != tt.wantSlept {
+			t.Errorf("%s: waited %v, want %v", tt.name, clock.slept, tt.wantSlept)
+		}
+	}
+}
+
+func TestUseUsernameOTP(t *testing.T) {
+	otp := "otpauth://totp/alice?secret=" + rfcSecretSHA1
+	newOTPMenu := func() (*Menu, *Entry) {
+		m := NewMenu()
+		m.Database = newTestDatabase(newTestEntry("Title", "Mail", "UserName", "{S:Login}", "Login", "alice", OTP, otp))
+		m.Configuration.General.OTPThreshold = 5
+		setFakeClock(m, time.Unix(1046, 0))
+		return m, testEntry(t, m.Database, "Mail")
+	}
+	next := createTestOTP(t, otp, 1050)
+	if next == createTestOTP(t, otp, 1046) {
+		t.Fatal("the codes at 1046 and 1050 are the same")
+	}
+
+	// Typed as username, tab and the next code
+	typed := filepath.Join(t.TempDir(), "typed")
+	m, e := newOTPMenu()
+	m.Configuration.General.Autotype = true
+	m.Configuration.General.AutotypeTool = AutotypeToolCustom
+	m.Configuration.Executable.CustomAutotypeText = "sh -c 'cat >> " + typed + "'"
+	m.Configuration.Executable.CustomAutotypeKey = "sh -c 'printf \"[%s]\" \"$0\" >> " + typed + "'"
+	if err := m.useUsernameOTP(e); err != nil {
+		t.Fatalf("useUsernameOTP() with autotype failed: %s", err.String())
+	}
+	if got, err := ioutil.ReadFile(typed); err != nil || string(got) != "alice[Tab]"+next {
+		t.Errorf("useUsernameOTP() typed %q, %v, want %q", got, err, "alice[Tab]"+next)
+	}
+
+	// Copied as username, then the code once confirmed
+	var output bytes.Buffer
+	m, e = newOTPMenu()
+	m.Output = &output
+	m.Configuration.General.ClipboardTool = ClipboardToolStdout
+	prompter := &fakePrompter{answers: []string{"Copy OTP"}}
+	m.Prompter = prompter
+	if err := m.useUsernameOTP(e); err != nil {
+		t.Fatalf("useUsernameOTP() failed: %s", err.String())
+	}
+	if want := "alice\n" + next + "\n"; output.String() != want {
+		t.Errorf("useUsernameOTP() copied %q, want %q", output.String(), want)
+	}
+	if len(prompter.labels) != 1 || prompter.labels[0] != "Username copied" {
+		t.Errorf("useUsernameOTP() prompts %q, want the confirmation", prompter.labels)
+	}
+
+	// The code isn't copied if not confirmed
+	output.Reset()
+	m, e = newOTPMenu()
+	m.Output = &output
+	m.Configuration.General.ClipboardTool = ClipboardToolStdout
+	m.Prompter = &fakePrompter{}
+	if err := m.useUsernameOTP(e); err == nil {
+		t.Error("useUsernameOTP() not confirmed succeeded, want cancelled")
+	}
+	if output.String() != "alice\n" {
+		t.Errorf("useUsernameOTP() not confirmed copied %q, want only the username", output.String())
+	}
+}
+
+func TestKeePassOTPFields(t *testing.T) {
+	sha1Key := "12345678901234567890"
+	sha256Key := strings.Repeat("1234567890", 4)[:32]
+	sha512Key := strings.Repeat("1234567890", 7)[:64]
+
+	// RFC 6238 codes at T=59 with the secret in every encoding
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"utf-8", []string{TimeOtpPrefix + "Secret", sha1Key}, "94287082"},
+		{"hex", []string{TimeOtpPrefix + "Secret-Hex", hex.EncodeToString([]byte(sha1Key))}, "94287082"},
+		{"spaced hex", []string{TimeOtpPrefix + "Secret-Hex", "3132 3334 3536 3738 3930 3132 3334 3536 3738 3930"}, "94287082"},
+		{"base32", []string{TimeOtpPrefix + "Secret-Base32", rfcSecretSHA1}, "94287082"},
+		{"base64", []string{TimeOtpPrefix + "Secret-Base64", base64.StdEncoding.EncodeToString([]byte(sha1Key))}, "94287082"},
+		{"sha256", []string{TimeOtpPrefix + "Secret", sha256Key, TimeOtpPrefix + "Algorithm", "HMAC-SHA-256"}, "46119246"},
+		{"sha512", []string{TimeOtpPrefix + "Secret-Hex", hex.EncodeToString([]byte(sha512Key)), TimeOtpPrefix + "Algorithm", "HMAC-SHA-512"}, "90693936"},
+		{"period", []string{TimeOtpPrefix + "Secret", sha1Key, TimeOtpPrefix + "Period", "60"}, "84755224"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := newTestEntry(append(tt.fields, TimeOtpPrefix+"Length", "8")...)
+			if !HasOTP(entry) {
+				t.Fatal("HasOTP() = false, want true")
+			}
+			code, err := CreateOTP(entry, 59)
+			if err != nil || code != tt.want {
+				t.Errorf("CreateOTP() = %s, %v, want %s", code, err, tt.want)
+			}
+		})
+	}
+
+	// RFC 4226 codes with the counter of the entry
+	for counter, want := range []string{"755224", "287082", "359152"} {
+		entry := newTestEntry(HmacOtpPrefix+"Secret-Base64", base64.StdEncoding.EncodeToString([]byte(sha1Key)), HmacOtpPrefix+"Counter", fmt.Sprint(counter))
+		if code, err := CreateOTP(entry, 0); err != nil || code != want {
+			t.Errorf("HOTP with counter %d = %s, %v, want %s", counter, code, err, want)
+		}
+	}
+
+	for _, fields := range [][]string{
+		{TimeOtpPrefix + "Secret-Hex", "not hex"},
+		{TimeOtpPrefix + "Secret-Base64", "not base64!"},
+		{TimeOtpPrefix + "Secret-Base32", "1"},
+		{TimeOtpPrefix + "Secret", sha1Key, TimeOtpPrefix + "Algorithm", "HMAC-MD5"},
+		{TimeOtpPrefix + "Secret", sha1Key, TimeOtpPrefix + "Length", "x"},
+		{HmacOtpPrefix + "Secret", sha1Key, HmacOtpPrefix + "Counter", "-1"},
+	} {
+		if _, err := CreateOTPAuth(newTestEntry(fields...)); err == nil {
+			t.Errorf("CreateOTPAuth(%q) succeeded, want an error", fields)
+		}
+	}
+}
+
+func TestSteamOTP(t *testing.T) {
+	// The code of the RFC 6238 secret at T=59, its truncated HMAC is 1094287082
+	for _, fields := range [][]string{
+		{OTP, "otpauth://totp/Steam:alice?secret=" + rfcSecretSHA1 + "&encoder=steam"},
+		{OTP, "key=" + rfcSecretSHA1 + "&encoder=Steam"},
+		{TOTPSEED, rfcSecretSHA1, TOTPSETTINGS, "30;S"},
+	} {
+		code, err := CreateOTP(newTestEntry(fields...), 59)
+		if err != nil || code != "PV9M4" {
+			t.Errorf("CreateOTP(%q) = %s, %v, want PV9M4", fields, code, err)
+		}
+	}
+	if _, err := CreateOTPAuth(newTestEntry(OTP, "otpauth://totp/alice?secret="+rfcSecretSHA1+"&encoder=other")); err == nil {
+		t.Error("CreateOTPAuth() with an unknown encoder succeeded, want an error")
+	}
+}
+
+func TestHasOTP(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"UserName", "alice"}, false},
+		{[]string{OTP, "otpauth://totp/alice?secret=" + rfcSecretSHA1}, true},
+		{[]string{TOTPSEED, rfcSecretSHA1}, true},
+		{[]string{TOTPSETTINGS, "30;6"}, false},
+		{[]string{TimeOtpPrefix + "Secret-Base32", rfcSecretSHA1}, true},
+		{[]string{TimeOtpPrefix + "Secret-Base32", ""}, false},
+		{[]string{TimeOtpPrefix + "Period", "30"}, false},
+		{[]string{TimeOtpPrefix + "Length", "8", TimeOtpPrefix + "Algorithm", "HMAC-SHA-256"}, false},
+		{[]string{HmacOtpPrefix + "Secret-Hex", "3132"}, true},
+		{[]string{HmacOtpPrefix + "Counter", "3"}, false},
+	}
+	for _, tt := range tests {
+		if got := HasOTP(newTestEntry(tt.fields...)); got != tt.want {
+			t.Errorf("HasOTP(%q) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package kpmenulib
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// placeholderMaxDepth is the limit of nested placeholders, deeper values are left as they are
+const placeholderMaxDepth = 12
+
+// referenceFields maps the field codes of {REF:<wanted>@<search in>:<text>} to the entry fields,
+// I is the UUID and O (search in only) any custom field
+var referenceFields = map[byte]string{
+	'T': "Title",
+	'U': "UserName",
+	'P': "Password",
+	'A': "URL",
+	'N': "Notes",
+}
+
+// datePlaceholders maps the date placeholders to their time layouts, {DT_UTC_*} use the same layouts in UTC
+var datePlaceholders = map[string]string{
+	"DT_SIMPLE": "20060102150405",
+	"DT_YEAR":   "2006",
+	"DT_MONTH":  "01",
+	"DT_DAY":    "02",
+	"DT_HOUR":   "15",
+	"DT_MINUTE": "04",
+	"DT_SECOND": "05",
+}
+
+// placeholderResolver resolves the placeholders of values, keeping the fields being resolved to detect cycles
+type placeholderResolver struct {
+	db        *Database
+	now       time.Time
+	resolving map[string]bool
+}
+
+func newPlaceholderResolver(db *Database) *placeholderResolver {
+	return &placeholderResolver{db: db, now: time.Now(), resolving: make(map[string]bool)}
+}
+
+// ResolvePlaceholders replaces the KeePass placeholders of the text with the values of the entry,
+// unknown placeholders, references not found and cycles are left as they are
+func (db *Database) ResolvePlaceholders(text string, e *Entry) string {
+	return newPlaceholderResolver(db).resolve(text, e, 0)
+}
+
+// EntryValue returns the value of the entry field with its placeholders resolved
+func (db *Database) EntryValue(e *Entry, field string) string {
+	value, _ := newPlaceholderResolver(db).field(e, field, 0)
+	return value
+}
+
+// ResolvedEntry returns a copy of the entry with the placeholders of its values resolved
+func (db *Database) ResolvedEntry(e *Entry) gokeepasslib.Entry {
+	resolved := e.FullEntry
+	resolved.Values = append([]gokeepasslib.ValueData{}, resolved.Values...)
+	for i, v := range resolved.Values {
+		resolved.Values[i].Value.Content = db.EntryValue(e, v.Key)
+	}
+	return resolved
+}
+
+func (r *placeholderResolver) resolve(text string, e *Entry, depth int) string {
+	if depth > placeholderMaxDepth {
+		log.Printf("placeholders of entry %s are nested too deep", e.FullPath())
+		return text
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(text, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		if next := strings.IndexByte(text[start+1:end], '{'); next >= 0 {
+			// Not a placeholder, try with the next {
+			b.WriteString(text[:start+1+next])
+			text = text[start+1+next:]
+			continue
+		}
+
+		b.WriteString(text[:start])
+		if value, ok := r.placeholder(text[start+1:end], e, depth); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(text[start : end+1])
+		}
+		text = text[end+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// placeholder returns the value of the placeholder, ok is false if it can't be resolved
+func (r *placeholderResolver) placeholder(name string, e *Entry, depth int) (value string, ok bool) {
+	upper := strings.ToUpper(name)
+	switch upper {
+	case "TITLE":
+		return r.field(e, "Title", depth)
+	case "USERNAME":
+		return r.field(e, "UserName", depth)
+	case "URL":
+		return r.field(e, "URL", depth)
+	case "PASSWORD":
+		return r.field(e, "Password", depth)
+	case "NOTES":
+		return r.field(e, "Notes", depth)
+	}
+
+	switch {
+	case strings.HasPrefix(upper, "S:"):
+		if e.FullEntry.Get(name[2:]) == nil {
+			return "", false
+		}
+		return r.field(e, name[2:], depth)
+	case strings.HasPrefix(upper, "REF:"):
+		return r.reference(name[4:], depth)
+	case strings.HasPrefix(upper, "DT_UTC_"):
+		if layout, ok := datePlaceholders["DT_"+upper[7:]]; ok {
+			return r.now.UTC().Format(layout), true
+		}
+	case strings.HasPrefix(upper, "DT_"):
+		if layout, ok := datePlaceholders[upper]; ok {
+			return r.now.Format(layout), true
+		}
+	}
+	return "", false
+}
+
+// field returns the value of the entry field with its placeholders resolved, empty if the entry hasn't it.
+// ok is false if the field is already being resolved
+func (r *placeholderResolver) field(e *Entry, field string, depth int) (value string, ok bool) {
+	key := e.HexUUID() + "/" + field
+	if r.resolving[key] {
+		log.Printf("field %s of entry %s references itself", field, e.FullPath())
+		return "", false
+	}
+	r.resolving[key] = true
+	defer delete(r.resolving, key)
+	return r.resolve(e.FullEntry.GetContent(field), e, depth+1), true
+}
+
+// reference resolves <wanted>@<search in>:<text>, the wanted field of the first entry
+// whose search in field contains the text (case insensitive) or has the UUID
+func (r *placeholderResolver) reference(spec string, depth int) (value string, ok bool) {
+	if len(spec) < 4 || spec[1] != '@' || spec[3] != ':' {
+		return "", false
+	}
+	wanted, searchIn, text := strings.ToUpper(spec[:1])[0], strings.ToUpper(spec[2:3])[0], spec[4:]
+	if _, ok := referenceFields[wanted]; !ok && wanted != 'I' {
+		return "", false
+	}
+
+	var match func(e *Entry) bool
+	switch searchIn {
+	case 'I':
+		uuid, err := ParseUUID(text)
+		if err != nil {
+			return "", false
+		}
+		match = func(e *Entry) bool {
+			return e.UUID.Compare(uuid)
+		}
+	case 'O':
+		match = func(e *Entry) bool {
+			for _, v := range e.FullEntry.Values {
+				if !isStandardField(v.Key) && containsText(v.Value.Content, text) {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		field, ok := referenceFields[searchIn]
+		if !ok {
+			return "", false
+		}
+		match = func(e *Entry) bool {
+			return containsText(e.FullEntry.GetContent(field), text)
+		}
+	}
+
+	for i := range r.db.Entries {
+		e := &r.db.Entries[i]
+		if !match(e) {
+			continue
+		}
+		if wanted == 'I' {
+			return strings.ToUpper(e.HexUUID()), true
+		}
+		return r.field(e, referenceFields[wanted], depth)
+	}
+	return "", false
+}
+
+// isStandardField checks if the field is one of the fields every KeePass entry has
+func isStandardField(field string) bool {
+	switch field {
+	case "Title", "UserName", "Password", "URL", "Notes":
+		return true
+	}
+	return false
+}
+
+func containsText(value string, text string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(text))
+}
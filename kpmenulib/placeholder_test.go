@@ -0,0 +1,169 @@
+package kpmenulib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// newTestEntry makes an entry with the fields given as key, value pairs
+func newTestEntry(kv ...string) gokeepasslib.Entry {
+	e := gokeepasslib.NewEntry()
+	for i := 0; i+1 < len(kv); i += 2 {
+		SetEntryValue(&e, kv[i], kv[i+1], kv[i] == "Password")
+	}
+	return e
+}
+
+// newTestGroup makes a group with the entries
+func newTestGroup(name string, entries ...gokeepasslib.Entry) gokeepasslib.Group {
+	group := gokeepasslib.NewGroup()
+	group.Name = name
+	group.Entries = entries
+	return group
+}
+
+// newTestDatabase makes a loaded database with the entries into the group G
+func newTestDatabase(entries ...gokeepasslib.Entry) *Database {
+	return newTestDatabaseGroups(newTestGroup("G", entries...))
+}
+
+// newTestDatabaseGroups makes a loaded database with the groups into the root group
+func newTestDatabaseGroups(groups ...gokeepasslib.Group) *Database {
+	root := newTestGroup("Root")
+	root.Groups = groups
+
+	db := NewDatabase()
+	db.Keepass = gokeepasslib.NewDatabase()
+	db.Keepass.Content.Root.Groups = []gokeepasslib.Group{root}
+	db.IterateDatabase()
+	db.Loaded = true
+	return db
+}
+
+// testEntry returns the entry of the database with the title
+func testEntry(t *testing.T, db *Database, title string) *Entry {
+	for i := range db.Entries {
+		if db.Entries[i].FullEntry.GetTitle() == title {
+			return &db.Entries[i]
+		}
+	}
+	t.Fatalf("entry %s not found", title)
+	return nil
+}
+
+func TestResolvePlaceholders(t *testing.T) {
+	target := newTestEntry("Title", "Target", "UserName", "alice", "Password", "secret", "URL", "https://example.com", "Notes", "notes", "Custom", "custom value")
+	db := newTestDatabase(
+		target,
+		newTestEntry("Title", "Source", "UserName", "{USERNAME}", "Password", "x{PASSWORD}", "Notes", "n", "A", "a{S:B}", "B", "b{S:A}"),
+	)
+	source := testEntry(t, db, "Source")
+	hexUUID := testEntry(t, db, "Target").HexUUID()
+	uuid := testEntry(t, db, "Target").UUID
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"no placeholders", "plain text", "plain text"},
+		{"fields", "{TITLE} {username} {Notes}", "Source {USERNAME} n"},
+		{"custom field", "{S:A}", "ab{S:A}"},
+		{"missing custom field", "{S:Missing}", "{S:Missing}"},
+		{"unknown", "{UNKNOWN} {}", "{UNKNOWN} {}"},
+		{"unclosed", "{TITLE", "{TITLE"},
+		{"nested braces", "{{TITLE}}", "{Source}"},
+		{"reference by uuid", "{REF:U@I:" + hexUUID + "}", "alice"},
+		{"reference by uppercase uuid", "{REF:P@I:" + strings.ToUpper(hexUUID) + "}", "secret"},
+		{"reference by base64 uuid", "{REF:A@I:" + base64.StdEncoding.EncodeToString(uuid[:]) + "}", "https://example.com"},
+		{"reference by title", "{ref:n@t:target}", "notes"},
+		{"reference by username", "{REF:T@U:ALI}", "Target"},
+		{"reference by url", "{REF:T@A:example.com}", "Target"},
+		{"reference by custom field", "{REF:T@O:custom val}", "Target"},
+		{"reference of the uuid", "{REF:I@T:Target}", strings.ToUpper(hexUUID)},
+		{"reference not found", "{REF:U@T:Nobody}", "{REF:U@T:Nobody}"},
+		{"invalid reference", "{REF:X@T:Target} {REF:U@X:Target} {REF:U@I:nothex} {REF:U}", "{REF:X@T:Target} {REF:U@X:Target} {REF:U@I:nothex} {REF:U}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db.ResolvePlaceholders(tt.text, source); got != tt.want {
+				t.Errorf("ResolvePlaceholders(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntryValue(t *testing.T) {
+	chain := newTestEntry("Title", "Chain")
+	for i := 0; i < 20; i++ {
+		SetEntryValue(&chain, fmt.Sprintf("F%d", i), fmt.Sprintf("{S:F%d}", i+1), false)
+	}
+	SetEntryValue(&chain, "F20", "end", false)
+	db := newTestDatabase(
+		newTestEntry("Title", "Self", "UserName", "{S:Missing}", "Password", "x{PASSWORD}", "A", "a{S:B}", "B", "b{S:A}"),
+		newTestEntry("Title", "Loop1", "Notes", "1{REF:N@T:Loop2}"),
+		newTestEntry("Title", "Loop2", "Notes", "2{REF:N@T:Loop1}"),
+		chain,
+	)
+
+	tests := []struct {
+		entry string
+		field string
+		want  string
+	}{
+		{"Self", "UserName", "{S:Missing}"},
+		{"Self", "Password", "x{PASSWORD}"},
+		{"Self", "A", "ab{S:A}"},
+		{"Self", "B", "ba{S:B}"},
+		{"Self", "Missing", ""},
+		{"Loop1", "Notes", "12{REF:N@T:Loop1}"},
+		{"Loop2", "Notes", "21{REF:N@T:Loop2}"},
+		{"Chain", "F8", "end"},
+		{"Chain", "F0", "{S:F13}"},
+	}
+	for _, tt := range tests {
+		if got := db.EntryValue(testEntry(t, db, tt.entry), tt.field); got != tt.want {
+			t.Errorf("EntryValue(%s, %s) = %q, want %q", tt.entry, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestDatePlaceholders(t *testing.T) {
+	db := newTestDatabase(newTestEntry("Title", "Date"))
+	e := testEntry(t, db, "Date")
+	r := newPlaceholderResolver(db)
+	r.now = time.Date(2024, 3, 9, 1, 2, 3, 0, time.FixedZone("UTC+2", 2*60*60))
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"{DT_SIMPLE}", "20240309010203"},
+		{"{DT_YEAR}-{DT_MONTH}-{DT_DAY} {DT_HOUR}:{DT_MINUTE}:{DT_SECOND}", "2024-03-09 01:02:03"},
+		{"{DT_UTC_SIMPLE}", "20240308230203"},
+		{"{dt_utc_day} {DT_UTC_HOUR}", "08 23"},
+		{"{DT_UNKNOWN} {DT_UTC_UNKNOWN}", "{DT_UNKNOWN} {DT_UTC_UNKNOWN}"},
+	}
+	for _, tt := range tests {
+		if got := r.resolve(tt.text, e, 0); got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResolvedEntry(t *testing.T) {
+	db := newTestDatabase(newTestEntry("Title", "Entry", "UserName", "user", "Notes", "{USERNAME}@{TITLE}"))
+	e := testEntry(t, db, "Entry")
+	resolved := db.ResolvedEntry(e)
+	if got := resolved.GetContent("Notes"); got != "user@Entry" {
+		t.Errorf("resolved Notes = %q, want user@Entry", got)
+	}
+	if got := e.FullEntry.GetContent("Notes"); got != "{USERNAME}@{TITLE}" {
+		t.Errorf("the entry is changed, Notes = %q", got)
+	}
+}
@@ -2,13 +2,14 @@ package kpmenulib
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"log"
 	"os/exec"
 	"regexp"
 	"strings"
-	"time"
 
-	"github.com/google/shlex"
+	"github.com/tobischo/gokeepasslib/v3"
 )
 
 // MenuSelection is an enum used for prompt menu selection
@@ -16,17 +17,45 @@ type MenuSelection int
 
 // MenuSelections enum values
 const (
-	MenuShow   = MenuSelection(iota) // Show entries
-	MenuReload                       // Reload database
-	MenuExit                         // Exit
+	MenuShow     = MenuSelection(iota) // Show entries
+	MenuAddEntry                       // Add entry
+	MenuGenerate                       // Generate password
+	MenuReload                         // Reload database
+	MenuExit                           // Exit
 )
 
 var menuSelections = [...]string{
 	"Show entries",
+	"Add entry",
+	"Generate password",
 	"Reload database",
 	"Exit",
 }
 
+// FieldAction is an enum used for the action chosen at field selection
+type FieldAction int
+
+// FieldAction enum values
+const (
+	FieldValue       = FieldAction(iota) // Use the value of a field
+	FieldAutotype                        // Autotype the sequence of the entry
+	FieldEdit                            // Edit a field of the entry
+	FieldUsernameOTP                     // Use the username, then the OTP
+	FieldAddOTP                          // Add an OTP to the entry
+)
+
+// FieldSelection is the result of a field selection
+type FieldSelection struct {
+	Action FieldAction // Chosen action
+	Field  string      // Selected field, empty if not a field
+	Value  string      // Value to use
+}
+
+// GroupParent is the item used to go back to the parent group
+const GroupParent = ".."
+
+var formatEntryRegex = regexp.MustCompile(`\{[^{}]+\}`)
+
 type entryItem struct {
 	Title string
 	Entry *Entry
@@ -41,113 +70,30 @@ type ErrorPrompt struct {
 // PromptPassword executes dmenu to ask for database password
 // Returns the written password
 func PromptPassword(menu *Menu) (string, ErrorPrompt) {
-	// Prepare dmenu/rofi
-	var command []string
-	switch menu.Configuration.General.Menu {
-	case "rofi":
-		command = []string{
-			"rofi",
-			"-i",
-			"-dmenu",
-			"-p", menu.Configuration.Style.TextPassword,
-			"-password",
-		}
-	case "wofi":
-		command = []string{
-			"wofi",
-			"-i",
-			"-d",
-			"-p", menu.Configuration.Style.TextPassword,
-			"--password",
-		}
-	case "dmenu":
-		command = []string{
-			"dmenu",
-			"-i",
-			"-p", menu.Configuration.Style.TextPassword,
-			"-nb", menu.Configuration.Style.PasswordBackground,
-			"-nf", menu.Configuration.Style.PasswordBackground,
-		}
-	case "custom":
-		var err error
-		command, err = shlex.Split(menu.Configuration.Executable.CustomPromptPassword)
-		if err != nil {
-			var errorPrompt ErrorPrompt
-			errorPrompt.Error = fmt.Errorf("failed to parse custom prompt password, exiting")
-			return "", errorPrompt
-		}
-	}
-
-	// Add custom arguments
-	if menu.Configuration.Style.ArgsPassword != "" {
-		command = append(command, strings.Split(menu.Configuration.Style.ArgsPassword, " ")...)
+	prompter, errorPrompt := getPrompter(menu)
+	if errorPrompt.Error != nil {
+		return "", errorPrompt
 	}
 
 	// Execute prompt
-	return executePrompt(command, nil)
+	return prompter.Password(NewPromptOptions(menu.Configuration, StagePassword))
 }
 
 // PromptMenu executes dmenu to ask for menu selection
 // Returns the MenuSelection chosen
 func PromptMenu(menu *Menu) (MenuSelection, ErrorPrompt) {
 	var selection MenuSelection
-	var input strings.Builder
-
-	// Prepare dmenu/rofi
-	var command []string
-	switch menu.Configuration.General.Menu {
-	case "rofi":
-		command = []string{
-			"rofi",
-			"-i",
-			"-dmenu",
-			"-p", menu.Configuration.Style.TextMenu,
-		}
-	case "wofi":
-		command = []string{
-			"wofi",
-			"-i",
-			"-d",
-			"-p", menu.Configuration.Style.TextMenu,
-		}
-	case "dmenu":
-		command = []string{
-			"dmenu",
-			"-i",
-			"-p", menu.Configuration.Style.TextMenu,
-		}
-	case "custom":
-		var err error
-		command, err = shlex.Split(menu.Configuration.Executable.CustomPromptMenu)
-		if err != nil {
-			var errorPrompt ErrorPrompt
-			errorPrompt.Cancelled = true
-			errorPrompt.Error = fmt.Errorf("failed to parse custom prompt menu, exiting")
-			return 0, errorPrompt
-		}
-	}
 
-	// Add custom arguments
-	if menu.Configuration.Style.ArgsMenu != "" {
-		command = append(command, strings.Split(menu.Configuration.Style.ArgsMenu, " ")...)
-	}
-
-	// Prepare input (dmenu items)
-	for _, e := range menuSelections {
-		input.WriteString(e + "\n")
+	prompter, err := getPrompter(menu)
+	if err.Error != nil {
+		return selection, err
 	}
 
 	// Execute prompt
-	result, err := executePrompt(command, strings.NewReader(input.String()))
-	if err.Error == nil && !err.Cancelled {
+	i, err := ChooseIndex(prompter, NewPromptOptions(menu.Configuration, StageMenu), menuSelections[:])
+	if err.Error == nil && !err.Cancelled && i >= 0 {
 		// Get selected menu item
-		for ind, sel := range menuSelections {
-			// Match for entry title and selected entry
-			if sel == result {
-				selection = MenuSelection(ind)
-				break
-			}
-		}
+		selection = MenuSelection(i)
 	}
 	return selection, err
 }
@@ -156,136 +102,173 @@ func PromptMenu(menu *Menu) (MenuSelection, ErrorPrompt) {
 // Returns the selected entry
 func PromptEntries(menu *Menu) (*Entry, ErrorPrompt) {
 	var entry Entry
-	var input strings.Builder
-
-	// Prepare dmenu/rofi
-	var command []string
-	switch menu.Configuration.General.Menu {
-	case "rofi":
-		command = []string{
-			"rofi",
-			"-i",
-			"-dmenu",
-			"-p", menu.Configuration.Style.TextEntry,
-		}
-	case "wofi":
-		command = []string{
-			"wofi",
-			"-i",
-			"-d",
-			"-p", menu.Configuration.Style.TextEntry,
-		}
-	case "dmenu":
-		command = []string{
-			"dmenu",
-			"-i",
-			"-p", menu.Configuration.Style.TextEntry,
-		}
-	case "custom":
-		var err error
-		command, err = shlex.Split(menu.Configuration.Executable.CustomPromptEntries)
-		if err != nil {
-			var errorPrompt ErrorPrompt
-			errorPrompt.Error = fmt.Errorf("failed to parse custom prompt entries, exiting")
-			return nil, errorPrompt
-		}
-	}
 
-	// Add custom arguments
-	if menu.Configuration.Style.ArgsEntry != "" {
-		command = append(command, strings.Split(menu.Configuration.Style.ArgsEntry, " ")...)
+	prompter, errPrompt := getPrompter(menu)
+	if errPrompt.Error != nil {
+		return &entry, errPrompt
 	}
 
 	// Prepare a list of entries
 	// Identified by the formatted title and the entry pointer
 	var listEntries []entryItem
-	reg, err := regexp.Compile(`{[a-zA-Z]+\}`)
-	if err != nil {
-		return &entry, ErrorPrompt{
-			Cancelled: false,
-			Error:     err,
-		}
+	for i := range menu.Database.Entries {
+		// Be sure to point on the right entry, do not point to a local copy
+		e := &menu.Database.Entries[i]
+		listEntries = append(listEntries, entryItem{Title: formatEntry(menu.Database, menu.Configuration.Style.FormatEntry, e), Entry: e})
 	}
-	for i, e := range menu.Database.Entries {
-		// Format entry
-		title := menu.Configuration.Style.FormatEntry
-		matches := reg.FindAllString(title, -1)
-
-		// Replace every match
-		for _, match := range matches {
-			valueType := match[1 : len(match)-1] // Removes { and }
-			value := ""                          // By default empty value
-			vd := e.FullEntry.GetContent(valueType)
-			if vd != "" {
-				value = vd
+
+	// Move entries matching the active window on top
+	var matched []entryItem
+	if menu.Configuration.General.WindowMatch || menu.Configuration.General.WindowMatchSkip {
+		title, err := GetActiveWindowTitle(menu)
+		if err != nil {
+			log.Print(err)
+		} else {
+			var others []entryItem
+			for _, e := range listEntries {
+				if MatchWindow(menu.Database, e.Entry, title) {
+					matched = append(matched, e)
+				} else {
+					others = append(others, e)
+				}
+			}
+			log.Printf("%d entries match the active window", len(matched))
+
+			if len(matched) == 1 && menu.Configuration.General.WindowMatchSkip {
+				entry = *matched[0].Entry
+				return &entry, errPrompt
 			}
-			title = strings.Replace(title, match, value, -1)
+			listEntries = append(matched, others...)
 		}
-		// Be sure to point on the right entry, do not point to the local e
-		listEntries = append(listEntries, entryItem{Title: title, Entry: &menu.Database.Entries[i]})
 	}
 
-	// Prepare input (dmenu items)
+	if menu.Configuration.General.BrowseGroups {
+		return promptGroupEntries(menu, prompter, matched)
+	}
+
+	// Prepare menu items
+	var items []string
 	for _, e := range listEntries {
-		input.WriteString(e.Title + "\n")
+		items = append(items, e.Title)
 	}
 
 	// Execute prompt
-	result, errPrompt := executePrompt(command, strings.NewReader(input.String()))
-	if errPrompt.Error == nil && !errPrompt.Cancelled {
+	i, errPrompt := ChooseIndex(prompter, NewPromptOptions(menu.Configuration, StageEntry), items)
+	if errPrompt.Error == nil && !errPrompt.Cancelled && i >= 0 {
 		// Get selected entry
-		for _, e := range listEntries {
-			if e.Title == result {
-				entry = *e.Entry
-				break
-			}
-		}
+		entry = *listEntries[i].Entry
 	}
 	return &entry, errPrompt
 }
 
-// PromptFields executes dmenu to ask for a field selection
-// Returns the selected field value as string
-func PromptFields(menu *Menu, entry *Entry) (string, ErrorPrompt) {
-	var value string
-	var input strings.Builder
-
-	// Prepare dmenu/rofi
-	var command []string
-	switch menu.Configuration.General.Menu {
-	case "rofi":
-		command = []string{
-			"rofi",
-			"-i",
-			"-dmenu",
-			"-p", menu.Configuration.Style.TextEntry,
+// promptGroupEntries asks for an entry selection browsing the groups of the database,
+// a group is shown with a trailing / and GroupParent goes back to the parent group.
+// Items are mapped back by index, so groups and entries with the same name are distinguished.
+// The entries matching the active window are shown on top of the root group
+func promptGroupEntries(menu *Menu, prompter Prompter, matched []entryItem) (*Entry, ErrorPrompt) {
+	var entry Entry
+
+	// Entries by uuid, to get their path
+	entries := make(map[gokeepasslib.UUID]*Entry)
+	for i := range menu.Database.Entries {
+		entries[menu.Database.Entries[i].UUID] = &menu.Database.Entries[i]
+	}
+
+	var parents []gokeepasslib.Group
+	var path []string
+	current := menu.Database.RootGroup()
+	for {
+		// Prepare menu items
+		var items []string
+		if len(parents) > 0 {
+			items = append(items, GroupParent)
+		} else {
+			for _, e := range matched {
+				items = append(items, e.Title)
+			}
 		}
-	case "wofi":
-		command = []string{
-			"wofi",
-			"-i",
-			"-d",
-			"-p", menu.Configuration.Style.TextEntry,
+		for _, g := range current.Groups {
+			items = append(items, g.Name+"/")
 		}
-	case "dmenu":
-		command = []string{
-			"dmenu",
-			"-i",
-			"-p", menu.Configuration.Style.TextField,
+		var listEntries []entryItem
+		for _, kpEntry := range current.Entries {
+			if e, ok := entries[kpEntry.UUID]; ok {
+				listEntries = append(listEntries, entryItem{Title: formatEntry(menu.Database, menu.Configuration.Style.FormatEntry, e), Entry: e})
+				items = append(items, listEntries[len(listEntries)-1].Title)
+			}
 		}
-	case "custom":
-		var err error
-		command, err = shlex.Split(menu.Configuration.Executable.CustomPromptFields)
-		if err != nil {
-			var errorPrompt ErrorPrompt
-			errorPrompt.Error = fmt.Errorf("failed to parse custom prompt fields, exiting")
-			return "", errorPrompt
+
+		// Execute prompt, showing the current path
+		options := NewPromptOptions(menu.Configuration, StageEntry)
+		if len(path) > 0 {
+			options.Label = options.Label + " " + strings.Join(path, "/")
+		}
+		i, errPrompt := ChooseIndex(prompter, options, items)
+		if errPrompt.Error != nil || errPrompt.Cancelled || i < 0 {
+			return &entry, errPrompt
 		}
+
+		// Go back to the parent group
+		if len(parents) > 0 {
+			if i == 0 {
+				current = parents[len(parents)-1]
+				parents = parents[:len(parents)-1]
+				path = path[:len(path)-1]
+				continue
+			}
+			i--
+		} else {
+			// Entries matching the active window
+			if i < len(matched) {
+				entry = *matched[i].Entry
+				return &entry, errPrompt
+			}
+			i -= len(matched)
+		}
+
+		// Descend into the selected group
+		if i < len(current.Groups) {
+			parents = append(parents, current)
+			path = append(path, current.Groups[i].Name)
+			current = current.Groups[i]
+			continue
+		}
+
+		// Get selected entry
+		entry = *listEntries[i-len(current.Groups)].Entry
+		return &entry, errPrompt
 	}
+}
 
-	// Add custom arguments
-	if menu.Configuration.Style.ArgsField != "" {
-		command = append(command, strings.Split(menu.Configuration.Style.ArgsField, " ")...)
+// formatEntry replaces every {Field} of the format with the entry field value,
+// {Group} and {Path} are replaced with the group name and path of the entry.
+// KeePass placeholders are resolved, unknown ones are removed
+func formatEntry(db *Database, format string, e *Entry) string {
+	return formatEntryRegex.ReplaceAllStringFunc(format, func(match string) string {
+		name := match[1 : len(match)-1] // Removes { and }
+		switch {
+		case name == "Group":
+			return e.Group
+		case name == "Path":
+			return e.Path
+		case e.FullEntry.Get(name) != nil:
+			return db.EntryValue(e, name)
+		}
+		if value := db.ResolvePlaceholders(match, e); value != match {
+			return value
+		}
+		return ""
+	})
+}
+
+// PromptFields executes dmenu to ask for a field selection
+// Returns the selected field
+func PromptFields(menu *Menu, entry *Entry) (FieldSelection, ErrorPrompt) {
+	var selection FieldSelection
+
+	prompter, err := getPrompter(menu)
+	if err.Error != nil {
+		return selection, err
 	}
 
 	fields := []string{}
@@ -298,14 +281,14 @@ func PromptFields(menu *Menu, entry *Entry) (string, ErrorPrompt) {
 		}
 	}
 
-	var hasOTP bool
+	hasOTP := !menu.Configuration.General.NoOTP && HasOTP(entry.FullEntry)
 	// Populate with filling fields
 	if menu.Configuration.Database.FillOtherFields {
 		blacklistFields := strings.Split(menu.Configuration.Database.FillBlacklist, " ")
 
 		for _, v := range entry.FullEntry.Values {
-			if !menu.Configuration.General.NoOTP && (v.Key == OTP || v.Key == TOTPSEED) {
-				hasOTP = true
+			if hasOTP && isOTPField(v.Key) {
+				// Settings of the OTP, generated by its menu item
 				continue
 			}
 			if !contains(fields, v.Key) && !contains(blacklistFields, v.Key) {
@@ -316,40 +299,174 @@ func PromptFields(menu *Menu, entry *Entry) (string, ErrorPrompt) {
 		}
 	}
 
-	// Prepare input (dmenu items)
+	// Prepare menu items
 	const GenerateOTP = "Generate OTP"
+	const Autotype = "Autotype"
+	const EditEntry = "Edit entry"
+	const UsernameOTP = "Username + OTP"
+	const AddOTP = "Add OTP"
+	// Items are mapped back by index, so fields named as the actions are distinguished
+	var items []string
+	var actions []string
+	addItem := func(item string, action string) {
+		items = append(items, item)
+		actions = append(actions, action)
+	}
+	if menu.Configuration.General.Autotype {
+		addItem(Autotype, Autotype)
+	}
 	for _, f := range fields {
-		input.WriteString(f + "\n")
+		addItem(f, "")
 	}
+	hasUsername := entry.FullEntry.GetContent("UserName") != ""
 	if hasOTP {
-		input.WriteString(GenerateOTP + "\n")
+		otpItem := GenerateOTP
+		if preview := otpPreview(entry.FullEntry, menu.now().Unix()); preview != "" {
+			otpItem = fmt.Sprintf("%s (%s)", GenerateOTP, preview)
+		}
+		addItem(otpItem, GenerateOTP)
+		if hasUsername {
+			addItem(UsernameOTP, UsernameOTP)
+		}
+	}
+	if !menu.Configuration.General.NoOTP {
+		addItem(AddOTP, AddOTP)
 	}
+	addItem(EditEntry, EditEntry)
 
 	// Execute prompt
-	result, err := executePrompt(command, strings.NewReader(input.String()))
-	if err.Error == nil && !err.Cancelled {
-		if result == GenerateOTP {
-			var ev error
-			value, ev = CreateOTP(entry.FullEntry, time.Now().Unix())
+	i, err := ChooseIndex(prompter, NewPromptOptions(menu.Configuration, StageField), items)
+	if err.Error == nil && !err.Cancelled && i >= 0 {
+		switch actions[i] {
+		case Autotype:
+			selection.Action = FieldAutotype
+		case EditEntry:
+			selection.Action = FieldEdit
+		case AddOTP:
+			selection.Action = FieldAddOTP
+		case GenerateOTP:
+			var ev *ErrorDatabase
+			selection.Value, ev = menu.selectedOTP(entry)
 			if ev != nil {
 				err.Cancelled = true
-				err.Error = fmt.Errorf("failed to create otp: %s", ev)
-				return value, err
+				err.Error = errors.New(ev.String())
 			}
-			return value, err
-		}
-		// Check that the result is valid
-		if contains(fields, result) {
+		case UsernameOTP:
+			selection.Action = FieldUsernameOTP
+		default:
 			// Get field value
-			for _, v := range entry.FullEntry.Values {
-				if result == v.Key {
-					value = v.Value.Content
-					break
-				}
-			}
+			selection.Field = items[i]
+			selection.Value = menu.Database.EntryValue(entry, items[i])
 		}
 	}
-	return value, err
+	return selection, err
+}
+
+// otpPreview returns the TOTP code of the entry grouped for reading, with the seconds it is still valid.
+// Empty for HOTP, a code shown without incrementing the counter could be used again, or if the OTP can't be generated
+func otpPreview(a gokeepasslib.Entry, now int64) string {
+	otpa, err := CreateOTPAuth(a)
+	if err != nil || otpa.Type == HOTP {
+		return ""
+	}
+	code, err := otpa.Create(now)
+	if err != nil {
+		return ""
+	}
+	if otpa.Encoder != SteamEncoder && len(code)%2 == 0 {
+		// 123456 -> 123 456
+		code = code[:len(code)/2] + " " + code[len(code)/2:]
+	}
+	return fmt.Sprintf("%s, %ds", code, otpa.Remaining(now))
+}
+
+// PromptInput executes dmenu to ask for a free text
+// Returns the written text
+func PromptInput(menu *Menu, label string) (string, ErrorPrompt) {
+	return PromptInputChoice(menu, label, nil)
+}
+
+// PromptInputChoice executes dmenu to ask for a free text, suggesting the items
+// Returns the written text or the chosen item
+func PromptInputChoice(menu *Menu, label string, items []string) (string, ErrorPrompt) {
+	prompter, errorPrompt := getPrompter(menu)
+	if errorPrompt.Error != nil {
+		return "", errorPrompt
+	}
+
+	// Suggestions are made unique and mapped back by index, any other result is the written text
+	options := NewPromptOptions(menu.Configuration, StageInput)
+	options.Label = label
+	logDuplicates(items)
+	unique := uniqueItems(items)
+	result, errorPrompt := prompter.Choose(options, unique)
+	for i, item := range unique {
+		if item == result {
+			return items[i], errorPrompt
+		}
+	}
+	return result, errorPrompt
+}
+
+// PromptSecret executes dmenu to ask for a secret, hidden while typing
+// Returns the written secret
+func PromptSecret(menu *Menu, label string) (string, ErrorPrompt) {
+	prompter, errorPrompt := getPrompter(menu)
+	if errorPrompt.Error != nil {
+		return "", errorPrompt
+	}
+
+	options := NewPromptOptions(menu.Configuration, StagePassword)
+	options.Label = label
+	return prompter.Password(options)
+}
+
+// PromptGroup executes dmenu to ask for a group selection
+// Returns the selected group, nil if not found
+func PromptGroup(menu *Menu) (*GroupItem, ErrorPrompt) {
+	prompter, errorPrompt := getPrompter(menu)
+	if errorPrompt.Error != nil {
+		return nil, errorPrompt
+	}
+
+	groups := menu.Database.Groups()
+	var items []string
+	for _, g := range groups {
+		items = append(items, "/"+g.Path)
+	}
+
+	options := NewPromptOptions(menu.Configuration, StageInput)
+	options.Label = menu.Configuration.Style.TextGroup
+	i, errorPrompt := ChooseIndex(prompter, options, items)
+	if errorPrompt.Error != nil || errorPrompt.Cancelled || i < 0 {
+		return nil, errorPrompt
+	}
+	return &groups[i], errorPrompt
+}
+
+// PromptChoice executes dmenu to ask for a choice between the items
+// Returns the index of the chosen item, -1 if not found
+func PromptChoice(menu *Menu, label string, items []string) (int, ErrorPrompt) {
+	prompter, errorPrompt := getPrompter(menu)
+	if errorPrompt.Error != nil {
+		return -1, errorPrompt
+	}
+
+	options := NewPromptOptions(menu.Configuration, StageMenu)
+	options.Label = label
+	return ChooseIndex(prompter, options, items)
+}
+
+// getPrompter returns the prompter of the menu, the injected one or the configured one
+func getPrompter(menu *Menu) (Prompter, ErrorPrompt) {
+	if menu.Prompter != nil {
+		return menu.Prompter, ErrorPrompt{}
+	}
+	prompter, err := NewPrompter(menu.Configuration)
+	if err != nil {
+		return nil, ErrorPrompt{Cancelled: true, Error: err}
+	}
+	return prompter, ErrorPrompt{}
 }
 
 func executePrompt(command []string, input *strings.Reader) (result string, errorPrompt ErrorPrompt) {
@@ -0,0 +1,230 @@
+package kpmenulib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// fakePrompter answers with scripted results and records what it was asked
+type fakePrompter struct {
+	answers []string   // Results of the next calls, cancelled when there are none
+	items   [][]string // Items of every call
+	labels  []string   // Labels of every call
+}
+
+func (p *fakePrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	return "", ErrorPrompt{Cancelled: true}
+}
+
+func (p *fakePrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	p.items = append(p.items, items)
+	p.labels = append(p.labels, options.Label)
+	if len(p.answers) == 0 {
+		return "", ErrorPrompt{Cancelled: true}
+	}
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return answer, ErrorPrompt{}
+}
+
+// fakeIndexPrompter answers with scripted indexes
+type fakeIndexPrompter struct {
+	fakePrompter
+	indexes []int // Results of the next calls, cancelled when there are none
+}
+
+func (p *fakeIndexPrompter) ChooseIndex(options PromptOptions, items []string) (int, ErrorPrompt) {
+	p.items = append(p.items, items)
+	p.labels = append(p.labels, options.Label)
+	if len(p.indexes) == 0 {
+		return -1, ErrorPrompt{Cancelled: true}
+	}
+	i := p.indexes[0]
+	p.indexes = p.indexes[1:]
+	return i, ErrorPrompt{}
+}
+
+func TestPromptEntries(t *testing.T) {
+	m := NewMenu()
+	m.Database = newTestDatabase(
+		newTestEntry("Title", "Mail", "UserName", "alice", "Password", "first"),
+		newTestEntry("Title", "Bank", "UserName", "bob", "Password", "bank"),
+		newTestEntry("Title", "Mail", "UserName", "alice", "Password", "second"),
+	)
+
+	// Duplicated titles are made unique and mapped back by index
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"Mail - alice (1)", "first"},
+		{"Mail - alice (2)", "second"},
+		{"Bank - bob", "bank"},
+		{"Mail - alice", ""},
+	}
+	for _, tt := range tests {
+		p := &fakePrompter{answers: []string{tt.answer}}
+		m.Prompter = p
+		entry, err := PromptEntries(m)
+		if err.Error != nil || err.Cancelled {
+			t.Fatalf("PromptEntries() failed: %+v", err)
+		}
+		if got := entry.FullEntry.GetPassword(); got != tt.want {
+			t.Errorf("answering %q selected the entry with password %q, want %q", tt.answer, got, tt.want)
+		}
+		want := []string{"Mail - alice (1)", "Bank - bob", "Mail - alice (2)"}
+		if !reflect.DeepEqual(p.items, [][]string{want}) {
+			t.Errorf("shown items %q, want %q", p.items, want)
+		}
+	}
+
+	// Prompters returning indexes are shown the items as they are
+	p := &fakeIndexPrompter{indexes: []int{2}}
+	m.Prompter = p
+	entry, err := PromptEntries(m)
+	if err.Error != nil || err.Cancelled {
+		t.Fatalf("PromptEntries() failed: %+v", err)
+	}
+	if got := entry.FullEntry.GetPassword(); got != "second" {
+		t.Errorf("index 2 selected the entry with password %q, want second", got)
+	}
+	want := []string{"Mail - alice", "Bank - bob", "Mail - alice"}
+	if !reflect.DeepEqual(p.items, [][]string{want}) {
+		t.Errorf("shown items %q, want %q", p.items, want)
+	}
+
+	// Cancelled prompt
+	m.Prompter = &fakePrompter{}
+	if entry, err := PromptEntries(m); !err.Cancelled || entry.FullEntry.GetTitle() != "" {
+		t.Errorf("PromptEntries() = %q, %+v, want a cancelled prompt", entry.FullEntry.GetTitle(), err)
+	}
+}
+
+func TestPromptEntriesBrowseGroups(t *testing.T) {
+	web := newTestGroup("Web",
+		newTestEntry("Title", "Forum", "Password", "forum"),
+		newTestEntry("Title", "Mail/", "Password", "entry"),
+	)
+	web.Groups = []gokeepasslib.Group{newTestGroup("Mail", newTestEntry("Title", "Webmail", "Password", "webmail"))}
+
+	m := NewMenu()
+	m.Configuration.General.BrowseGroups = true
+	m.Configuration.Style.FormatEntry = "{Title}"
+	m.Database = newTestDatabaseGroups(web, newTestGroup("Private", newTestEntry("Title", "Bank", "Password", "bank")))
+
+	tests := []struct {
+		name    string
+		answers []string
+		want    string
+		items   [][]string
+		labels  []string
+	}{
+		{
+			name:    "subgroup",
+			answers: []string{"Web/", "Mail/ (1)", "Webmail"},
+			want:    "webmail",
+			items:   [][]string{{"Web/", "Private/"}, {GroupParent, "Mail/ (1)", "Forum", "Mail/ (2)"}, {GroupParent, "Webmail"}},
+			labels:  []string{"Entry", "Entry Web", "Entry Web/Mail"},
+		},
+		{
+			name:    "entry named as a group",
+			answers: []string{"Web/", "Mail/ (2)"},
+			want:    "entry",
+			items:   [][]string{{"Web/", "Private/"}, {GroupParent, "Mail/ (1)", "Forum", "Mail/ (2)"}},
+			labels:  []string{"Entry", "Entry Web"},
+		},
+		{
+			name:    "parent group",
+			answers: []string{"Web/", GroupParent, "Private/", "Bank"},
+			want:    "bank",
+			items:   [][]string{{"Web/", "Private/"}, {GroupParent, "Mail/ (1)", "Forum", "Mail/ (2)"}, {"Web/", "Private/"}, {GroupParent, "Bank"}},
+			labels:  []string{"Entry", "Entry Web", "Entry", "Entry Private"},
+		},
+		{
+			name:    "cancelled",
+			answers: []string{"Web/"},
+			want:    "",
+			items:   [][]string{{"Web/", "Private/"}, {GroupParent, "Mail/ (1)", "Forum", "Mail/ (2)"}},
+			labels:  []string{"Entry", "Entry Web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakePrompter{answers: tt.answers}
+			m.Prompter = p
+			entry, _ := PromptEntries(m)
+			if got := entry.FullEntry.GetPassword(); got != tt.want {
+				t.Errorf("selected the entry with password %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(p.items, tt.items) {
+				t.Errorf("shown items %q, want %q", p.items, tt.items)
+			}
+			if !reflect.DeepEqual(p.labels, tt.labels) {
+				t.Errorf("shown labels %q, want %q", p.labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestPromptFields(t *testing.T) {
+	m := NewMenu()
+	m.Configuration.General.Autotype = true
+	m.Database = newTestDatabase(newTestEntry(
+		"Title", "Entry", "UserName", "alice", "Password", "secret",
+		"Edit entry", "custom", "Autotype", "typed",
+	))
+	entry := testEntry(t, m.Database, "Entry")
+
+	// Fields named as the actions are distinguished by their suffix
+	items := []string{"Autotype (1)", "Password", "UserName", "Title", "Edit entry (1)", "Autotype (2)", "Add OTP", "Edit entry (2)"}
+	tests := []struct {
+		answer string
+		want   FieldSelection
+	}{
+		{"Autotype (1)", FieldSelection{Action: FieldAutotype}},
+		{"Autotype (2)", FieldSelection{Field: "Autotype", Value: "typed"}},
+		{"Edit entry (1)", FieldSelection{Field: "Edit entry", Value: "custom"}},
+		{"Edit entry (2)", FieldSelection{Action: FieldEdit}},
+		{"Password", FieldSelection{Field: "Password", Value: "secret"}},
+		{"Add OTP", FieldSelection{Action: FieldAddOTP}},
+		{"Edit entry", FieldSelection{}},
+	}
+	for _, tt := range tests {
+		p := &fakePrompter{answers: []string{tt.answer}}
+		m.Prompter = p
+		got, err := PromptFields(m, entry)
+		if err.Error != nil || err.Cancelled {
+			t.Fatalf("PromptFields() failed: %+v", err)
+		}
+		if got != tt.want {
+			t.Errorf("answering %q selected %+v, want %+v", tt.answer, got, tt.want)
+		}
+		if !reflect.DeepEqual(p.items, [][]string{items}) {
+			t.Errorf("shown items %q, want %q", p.items, items)
+		}
+	}
+
+	// Prompters returning indexes
+	indexTests := []struct {
+		index int
+		want  FieldSelection
+	}{
+		{0, FieldSelection{Action: FieldAutotype}},
+		{4, FieldSelection{Field: "Edit entry", Value: "custom"}},
+		{5, FieldSelection{Field: "Autotype", Value: "typed"}},
+		{7, FieldSelection{Action: FieldEdit}},
+		{-1, FieldSelection{}},
+	}
+	for _, tt := range indexTests {
+		m.Prompter = &fakeIndexPrompter{indexes: []int{tt.index}}
+		got, err := PromptFields(m, entry)
+		if err.Error != nil || err.Cancelled {
+			t.Fatalf("PromptFields() failed: %+v", err)
+		}
+		if got != tt.want {
+			t.Errorf("index %d selected %+v, want %+v", tt.index, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package kpmenulib
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// Prompter is the interface implemented by every menu backend
+type Prompter interface {
+	// Password asks for a secret value, hiding it while typing
+	Password(options PromptOptions) (string, ErrorPrompt)
+	// Choose asks to choose an item of the list, returns the chosen item
+	Choose(options PromptOptions, items []string) (string, ErrorPrompt)
+}
+
+// IndexPrompter is implemented by prompters able to return the index of the chosen item
+type IndexPrompter interface {
+	// ChooseIndex asks to choose an item of the list, returns its index or -1 if none
+	ChooseIndex(options PromptOptions, items []string) (int, ErrorPrompt)
+}
+
+// PromptStage is an enum used to identify which prompt is executed
+type PromptStage int
+
+// PromptStage enum values
+const (
+	StagePassword = PromptStage(iota) // Database password
+	StageMenu                         // Menu selection
+	StageEntry                        // Entry selection
+	StageField                        // Field selection
+	StageInput                        // Free text, uses the menu arguments and custom executable
+)
+
+// PromptOptions contains the options of a single prompt
+type PromptOptions struct {
+	Stage PromptStage // Stage of the prompt
+	Label string      // Label shown by the menu
+	Args  []string    // Additional arguments of the menu
+}
+
+// PrompterBackend describes a registered menu backend
+type PrompterBackend struct {
+	Executable string                        // Executable checked at startup, empty to skip the check
+	New        func(*Configuration) Prompter // Constructor of the prompter
+}
+
+var prompterBackends = map[string]PrompterBackend{}
+
+// RegisterPrompter registers a menu backend with the given name, usable with --menu
+func RegisterPrompter(name string, backend PrompterBackend) {
+	prompterBackends[name] = backend
+}
+
+// NewPrompter initializes the prompter configured as menu
+func NewPrompter(c *Configuration) (Prompter, error) {
+	backend, ok := prompterBackends[c.General.Menu]
+	if !ok {
+		return nil, fmt.Errorf("invalid menu option %s", c.General.Menu)
+	}
+	return backend.New(c), nil
+}
+
+// NewPromptOptions makes the options of the given stage with label and arguments taken from the configuration
+func NewPromptOptions(c *Configuration, stage PromptStage) PromptOptions {
+	var label, args string
+	switch stage {
+	case StagePassword:
+		label, args = c.Style.TextPassword, c.Style.ArgsPassword
+	case StageMenu, StageInput:
+		label, args = c.Style.TextMenu, c.Style.ArgsMenu
+	case StageEntry:
+		label, args = c.Style.TextEntry, c.Style.ArgsEntry
+	case StageField:
+		label, args = c.Style.TextField, c.Style.ArgsField
+	}
+
+	options := PromptOptions{Stage: stage, Label: label}
+	if args != "" {
+		options.Args = strings.Split(args, " ")
+	}
+	return options
+}
+
+func init() {
+	RegisterPrompter(PromptDmenu, PrompterBackend{
+		Executable: "dmenu",
+		New:        func(c *Configuration) Prompter { return &dmenuPrompter{configuration: c} },
+	})
+	RegisterPrompter(PromptRofi, PrompterBackend{
+		Executable: "rofi",
+		New:        func(c *Configuration) Prompter { return &rofiPrompter{} },
+	})
+	RegisterPrompter(PromptWofi, PrompterBackend{
+		Executable: "wofi",
+		New:        func(c *Configuration) Prompter { return &wofiPrompter{} },
+	})
+	RegisterPrompter(PromptBemenu, PrompterBackend{
+		Executable: "bemenu",
+		New:        func(c *Configuration) Prompter { return &bemenuPrompter{} },
+	})
+	RegisterPrompter(PromptFuzzel, PrompterBackend{
+		Executable: "fuzzel",
+		New:        func(c *Configuration) Prompter { return &fuzzelPrompter{} },
+	})
+	RegisterPrompter(PromptTofi, PrompterBackend{
+		Executable: "tofi",
+		New:        func(c *Configuration) Prompter { return &tofiPrompter{} },
+	})
+	RegisterPrompter(PromptFzf, PrompterBackend{
+		Executable: "fzf",
+		New:        func(c *Configuration) Prompter { return &fzfPrompter{} },
+	})
+	RegisterPrompter(PromptTty, PrompterBackend{
+		New: func(c *Configuration) Prompter { return &ttyPrompter{} },
+	})
+	RegisterPrompter(PromptCustom, PrompterBackend{
+		New: func(c *Configuration) Prompter { return &customPrompter{configuration: c} },
+	})
+}
+
+// dmenuPrompter hides the password by using the same color for background and text
+type dmenuPrompter struct {
+	configuration *Configuration
+}
+
+func (p *dmenuPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"dmenu",
+		"-i",
+		"-p", options.Label,
+		"-nb", p.configuration.Style.PasswordBackground,
+		"-nf", p.configuration.Style.PasswordBackground,
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *dmenuPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"dmenu",
+		"-i",
+		"-p", options.Label,
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+type rofiPrompter struct{}
+
+func (p *rofiPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"rofi",
+		"-i",
+		"-dmenu",
+		"-p", options.Label,
+		"-password",
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *rofiPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"rofi",
+		"-i",
+		"-dmenu",
+		"-p", options.Label,
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+func (p *rofiPrompter) ChooseIndex(options PromptOptions, items []string) (int, ErrorPrompt) {
+	command := []string{
+		"rofi",
+		"-i",
+		"-dmenu",
+		"-p", options.Label,
+		"-format", "i",
+	}
+	result, errorPrompt := executePrompt(append(command, options.Args...), itemsReader(items))
+	return parseIndex(result, len(items)), errorPrompt
+}
+
+type wofiPrompter struct{}
+
+func (p *wofiPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"wofi",
+		"-i",
+		"-d",
+		"-p", options.Label,
+		"--password",
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *wofiPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"wofi",
+		"-i",
+		"-d",
+		"-p", options.Label,
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+type bemenuPrompter struct{}
+
+func (p *bemenuPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"bemenu",
+		"-i",
+		"-p", options.Label,
+		"-x", "indicator",
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *bemenuPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"bemenu",
+		"-i",
+		"-p", options.Label,
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+type fuzzelPrompter struct{}
+
+func (p *fuzzelPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"fuzzel",
+		"--dmenu",
+		"--prompt", options.Label + ": ",
+		"--password",
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *fuzzelPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"fuzzel",
+		"--dmenu",
+		"--prompt", options.Label + ": ",
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+// tofiPrompter requires a match only when choosing, the password and the input stage are free text
+type tofiPrompter struct{}
+
+func (p *tofiPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command := []string{
+		"tofi",
+		"--prompt-text", options.Label + ": ",
+		"--require-match=false",
+		"--hide-input=true",
+	}
+	return executePrompt(append(command, options.Args...), nil)
+}
+
+func (p *tofiPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"tofi",
+		"--prompt-text", options.Label + ": ",
+	}
+	if options.Stage == StageInput {
+		command = append(command, "--require-match=false")
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+// customPrompter executes the custom executable configured for each stage
+type customPrompter struct {
+	configuration *Configuration
+}
+
+func (p *customPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	command, errorPrompt := p.command(options)
+	if errorPrompt.Error != nil {
+		return "", errorPrompt
+	}
+	return executePrompt(command, nil)
+}
+
+func (p *customPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command, errorPrompt := p.command(options)
+	if errorPrompt.Error != nil {
+		return "", errorPrompt
+	}
+	return executePrompt(command, itemsReader(items))
+}
+
+func (p *customPrompter) command(options PromptOptions) (command []string, errorPrompt ErrorPrompt) {
+	var executable, name string
+	switch options.Stage {
+	case StagePassword:
+		executable, name = p.configuration.Executable.CustomPromptPassword, "password"
+	case StageMenu, StageInput:
+		executable, name = p.configuration.Executable.CustomPromptMenu, "menu"
+	case StageEntry:
+		executable, name = p.configuration.Executable.CustomPromptEntries, "entries"
+	case StageField:
+		executable, name = p.configuration.Executable.CustomPromptFields, "fields"
+	}
+
+	command, err := shlex.Split(executable)
+	if err != nil {
+		errorPrompt.Cancelled = true
+		errorPrompt.Error = fmt.Errorf("failed to parse custom prompt %s, exiting", name)
+		return
+	}
+	command = append(command, options.Args...)
+	return
+}
+
+// ChooseIndex asks to choose an item of the list and returns its index, or -1 if the result isn't an item.
+// If the prompter can't return indexes, duplicated items are made unique with a suffix
+func ChooseIndex(prompter Prompter, options PromptOptions, items []string) (int, ErrorPrompt) {
+	logDuplicates(items)
+	if p, ok := prompter.(IndexPrompter); ok {
+		return p.ChooseIndex(options, items)
+	}
+
+	unique := uniqueItems(items)
+	result, errorPrompt := prompter.Choose(options, unique)
+	if errorPrompt.Error == nil && !errorPrompt.Cancelled {
+		for i, item := range unique {
+			if item == result {
+				return i, errorPrompt
+			}
+		}
+	}
+	return -1, errorPrompt
+}
+
+// logDuplicates logs the items shown more than once, they can be told apart only by their position
+func logDuplicates(items []string) {
+	count := make(map[string]int)
+	for _, item := range items {
+		count[item]++
+	}
+	for _, item := range items {
+		if count[item] > 1 {
+			log.Printf("%d items are shown as \"%s\"", count[item], item)
+			// Log it once
+			count[item] = 0
+		}
+	}
+}
+
+// uniqueItems returns the items adding a numeric suffix to the duplicated ones
+func uniqueItems(items []string) []string {
+	count := make(map[string]int)
+	for _, item := range items {
+		count[item]++
+	}
+
+	used := make(map[string]bool)
+	for _, item := range items {
+		used[item] = true
+	}
+
+	unique := make([]string, len(items))
+	next := make(map[string]int)
+	for i, item := range items {
+		if count[item] == 1 {
+			unique[i] = item
+			continue
+		}
+
+		// Find a suffix not used by other items
+		var candidate string
+		for {
+			next[item]++
+			candidate = fmt.Sprintf("%s (%d)", item, next[item])
+			if !used[candidate] {
+				break
+			}
+		}
+		used[candidate] = true
+		unique[i] = candidate
+	}
+	return unique
+}
+
+// parseIndex parses the index returned by a prompter, returns -1 if not valid
+func parseIndex(result string, length int) int {
+	i, err := strconv.Atoi(strings.TrimSpace(result))
+	if err != nil || i < 0 || i >= length {
+		return -1
+	}
+	return i
+}
+
+// itemsReader prepares the input of a menu, one item per line
+func itemsReader(items []string) *strings.Reader {
+	var input strings.Builder
+	for _, item := range items {
+		input.WriteString(item + "\n")
+	}
+	return strings.NewReader(input.String())
+}
@@ -0,0 +1,49 @@
+package kpmenulib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUniqueItems(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		want  []string
+	}{
+		{"empty", nil, []string{}},
+		{"unique", []string{"a", "b"}, []string{"a", "b"}},
+		{"duplicated", []string{"a", "b", "a", "a"}, []string{"a (1)", "b", "a (2)", "a (3)"}},
+		{"suffix already used", []string{"a", "a (1)", "a"}, []string{"a (2)", "a (1)", "a (3)"}},
+		{"duplicated suffix", []string{"a", "a", "a (1)", "a (1)"}, []string{"a (2)", "a (3)", "a (1) (1)", "a (1) (2)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uniqueItems(tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uniqueItems(%q) = %q, want %q", tt.items, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		result string
+		length int
+		want   int
+	}{
+		{"0", 3, 0},
+		{"2\n", 3, 2},
+		{" 1 ", 3, 1},
+		{"3", 3, -1},
+		{"-1", 3, -1},
+		{"", 3, -1},
+		{"a", 3, -1},
+		{"0", 0, -1},
+	}
+	for _, tt := range tests {
+		if got := parseIndex(tt.result, tt.length); got != tt.want {
+			t.Errorf("parseIndex(%q, %d) = %d, want %d", tt.result, tt.length, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package kpmenulib
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// writeTestImage saves the image as PNG into a temporary folder
+func writeTestImage(t *testing.T, img image.Image) string {
+	path := filepath.Join(t.TempDir(), "image.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestQRCode encodes the text as QR code image
+func newTestQRCode(t *testing.T, text string) image.Image {
+	matrix, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 200, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matrix
+}
+
+func TestDecodeQRCode(t *testing.T) {
+	uri := "otpauth://totp/Example:alice?secret=" + rfcSecretSHA1 + "&issuer=Example"
+
+	// QR code alone and into a screenshot
+	screenshot := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	draw.Draw(screenshot, screenshot.Bounds(), image.NewUniform(color.RGBA{200, 220, 240, 255}), image.Point{}, draw.Src)
+	code := newTestQRCode(t, uri)
+	draw.Draw(screenshot, code.Bounds().Add(image.Pt(430, 250)), code, image.Point{}, draw.Src)
+	for name, img := range map[string]image.Image{"qr code": code, "screenshot": screenshot} {
+		if got, err := DecodeQRCode(writeTestImage(t, img)); err != nil || got != uri {
+			t.Errorf("%s: DecodeQRCode() = %q, %v, want %q", name, got, err, uri)
+		}
+	}
+
+	// Errors
+	notImage := filepath.Join(t.TempDir(), "notes.txt")
+	if err := ioutil.WriteFile(notImage, []byte(uri), 0600); err != nil {
+		t.Fatal(err)
+	}
+	blank := image.NewGray(image.Rect(0, 0, 200, 200))
+	draw.Draw(blank, blank.Bounds(), image.White, image.Point{}, draw.Src)
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.png"), "no such file"},
+		{"not an image", notImage, "failed to read image"},
+		{"no qr code", writeTestImage(t, blank), "qr code not found"},
+	}
+	for _, tt := range tests {
+		if got, err := DecodeQRCode(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: DecodeQRCode() = %q, %v, want the error %q", tt.name, got, err, tt.want)
+		}
+	}
+}
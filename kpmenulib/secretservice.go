@@ -0,0 +1,667 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Secret Service names, see https://specifications.freedesktop.org/secret-service/
+const (
+	SecretServiceName       = "org.freedesktop.secrets"
+	secretServicePath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretCollectionPath    = dbus.ObjectPath("/org/freedesktop/secrets/collection/kpmenu")
+	secretAliasPath         = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	secretSessionPrefix     = "/org/freedesktop/secrets/session/"
+	secretPromptPrefix      = "/org/freedesktop/secrets/prompt/"
+	secretNoPrompt          = dbus.ObjectPath("/")
+	secretIfaceService      = "org.freedesktop.Secret.Service"
+	secretIfaceCollection   = "org.freedesktop.Secret.Collection"
+	secretIfaceItem         = "org.freedesktop.Secret.Item"
+	secretIfaceSession      = "org.freedesktop.Secret.Session"
+	secretIfacePrompt       = "org.freedesktop.Secret.Prompt"
+	secretIfaceProperties   = "org.freedesktop.DBus.Properties"
+	secretAlgorithmPlain    = "plain"
+	secretAlgorithmDH       = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+	secretPropertyLabel     = "org.freedesktop.Secret.Item.Label"
+	secretPropertyAttribute = "org.freedesktop.Secret.Item.Attributes"
+)
+
+// Secret Service errors
+var (
+	errSecretNotSupported = dbus.NewError("org.freedesktop.DBus.Error.NotSupported", []interface{}{"not supported by kpmenu"})
+	errSecretIsLocked     = dbus.NewError("org.freedesktop.Secret.Error.IsLocked", []interface{}{"the database is locked"})
+	errSecretNoSession    = dbus.NewError("org.freedesktop.Secret.Error.NoSession", []interface{}{"session not found"})
+	errSecretNoSuchObject = dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject", []interface{}{"object not found"})
+)
+
+// Second Oakley group of RFC 2409, used by the dh-ietf1024-sha256-aes128-cbc-pkcs7 algorithm
+var secretDHPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF", 16)
+
+// SecretService exposes a group of the database as collection of the freedesktop Secret Service
+type SecretService struct {
+	menu     *Menu
+	conn     *dbus.Conn
+	mutex    sync.Mutex                            // Used for sessions and prompts
+	sessions map[dbus.ObjectPath][]byte            // AES keys of sessions, nil for plain sessions
+	prompts  map[dbus.ObjectPath][]dbus.ObjectPath // Objects to unlock of prompts
+	counter  int
+}
+
+// secretValue is the Secret structure of the Secret Service
+type secretValue struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Exported interfaces, methods of every type are the methods of an interface
+type (
+	secretServiceIface    struct{ s *SecretService }
+	secretCollectionIface struct{ s *SecretService }
+	secretItemIface       struct{ s *SecretService }
+	secretSessionIface    struct{ s *SecretService }
+	secretPromptIface     struct{ s *SecretService }
+	secretPropertiesIface struct{ s *SecretService }
+)
+
+// StartSecretService connects to the session bus and exports the Secret Service
+func StartSecretService(m *Menu) (*SecretService, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the session bus: %v", err)
+	}
+	s := &SecretService{
+		menu:     m,
+		conn:     conn,
+		sessions: make(map[dbus.ObjectPath][]byte),
+		prompts:  make(map[dbus.ObjectPath][]dbus.ObjectPath),
+	}
+
+	for _, export := range []struct {
+		v       interface{}
+		path    dbus.ObjectPath
+		iface   string
+		subtree bool
+	}{
+		{secretServiceIface{s}, secretServicePath, secretIfaceService, false},
+		{secretCollectionIface{s}, secretServicePath + "/collection", secretIfaceCollection, true},
+		{secretCollectionIface{s}, secretServicePath + "/aliases", secretIfaceCollection, true},
+		{secretItemIface{s}, secretServicePath + "/collection", secretIfaceItem, true},
+		{secretSessionIface{s}, secretServicePath + "/session", secretIfaceSession, true},
+		{secretPromptIface{s}, secretServicePath + "/prompt", secretIfacePrompt, true},
+		{secretPropertiesIface{s}, secretServicePath, secretIfaceProperties, false},
+		{secretPropertiesIface{s}, secretServicePath + "/collection", secretIfaceProperties, true},
+		{secretPropertiesIface{s}, secretServicePath + "/aliases", secretIfaceProperties, true},
+	} {
+		if export.subtree {
+			err = conn.ExportSubtree(export.v, export.path, export.iface)
+		} else {
+			err = conn.Export(export.v, export.path, export.iface)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to export %s: %v", export.iface, err)
+		}
+	}
+
+	reply, err := conn.RequestName(SecretServiceName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to request %s: %v", SecretServiceName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("another secret service owns %s", SecretServiceName)
+	}
+	log.Printf("secret service listening on the session bus")
+	return s, nil
+}
+
+// Close releases the Secret Service name
+func (s *SecretService) Close() {
+	s.conn.ReleaseName(SecretServiceName)
+	s.conn.Close()
+}
+
+// entries returns the entries of the collection, nil if the database is locked
+func (s *SecretService) entries() []*Entry {
+	if !s.menu.Database.Loaded {
+		return nil
+	}
+	return s.menu.Database.ListEntries(EntryFilter{Group: s.menu.Configuration.General.SecretServiceGroup})
+}
+
+// item returns the entry of the item path, nil if not found
+func (s *SecretService) item(path dbus.ObjectPath) *Entry {
+	for _, e := range s.entries() {
+		if secretItemPath(e) == path {
+			return e
+		}
+	}
+	return nil
+}
+
+// search returns the item paths of entries with every given attribute
+func (s *SecretService) search(attributes map[string]string) []dbus.ObjectPath {
+	items := []dbus.ObjectPath{}
+	for _, e := range s.entries() {
+		entryAttributes := secretAttributes(e)
+		matched := true
+		for k, v := range attributes {
+			if entryAttributes[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			items = append(items, secretItemPath(e))
+		}
+	}
+	return items
+}
+
+// secret returns the password of the entry, encrypted for the session
+func (s *SecretService) secret(e *Entry, session dbus.ObjectPath) (secretValue, *dbus.Error) {
+	s.mutex.Lock()
+	key, ok := s.sessions[session]
+	s.mutex.Unlock()
+	if !ok {
+		return secretValue{}, errSecretNoSession
+	}
+
+	secret := secretValue{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       []byte(s.menu.Database.EntryValue(e, "Password")),
+		ContentType: "text/plain",
+	}
+	if key != nil {
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return secretValue{}, dbus.MakeFailedError(err)
+		}
+		secret.Parameters = iv
+		secret.Value = secretEncrypt(key, iv, secret.Value)
+	}
+	return secret, nil
+}
+
+// secretPlaintext returns the value of the secret, decrypted with the key of its session
+func (s *SecretService) secretPlaintext(secret secretValue) (string, *dbus.Error) {
+	s.mutex.Lock()
+	key, ok := s.sessions[secret.Session]
+	s.mutex.Unlock()
+	if !ok {
+		return "", errSecretNoSession
+	}
+	if key == nil {
+		return string(secret.Value), nil
+	}
+	value, err := secretDecrypt(key, secret.Parameters, secret.Value)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return string(value), nil
+}
+
+// newPrompt makes a prompt that unlocks the objects
+func (s *SecretService) newPrompt(objects []dbus.ObjectPath) dbus.ObjectPath {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counter++
+	path := dbus.ObjectPath(fmt.Sprintf("%s%d", secretPromptPrefix, s.counter))
+	s.prompts[path] = objects
+	return path
+}
+
+// completePrompt removes the prompt and emits its Completed signal
+func (s *SecretService) completePrompt(path dbus.ObjectPath, dismissed bool) {
+	s.mutex.Lock()
+	objects := s.prompts[path]
+	delete(s.prompts, path)
+	s.mutex.Unlock()
+	if dismissed {
+		objects = []dbus.ObjectPath{}
+	}
+	s.conn.Emit(path, secretIfacePrompt+".Completed", dismissed, dbus.MakeVariant(objects))
+}
+
+// OpenSession opens a session, plain or with keys exchanged by Diffie-Hellman
+func (i secretServiceIface) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	var key []byte
+	output := dbus.MakeVariant("")
+	switch algorithm {
+	case secretAlgorithmPlain:
+	case secretAlgorithmDH:
+		peer, ok := input.Value().([]byte)
+		if !ok {
+			return output, secretNoPrompt, dbus.MakeFailedError(errors.New("invalid public key"))
+		}
+		public, k, err := secretExchangeKeys(peer)
+		if err != nil {
+			return output, secretNoPrompt, dbus.MakeFailedError(err)
+		}
+		key = k
+		output = dbus.MakeVariant(public)
+	default:
+		return output, secretNoPrompt, errSecretNotSupported
+	}
+
+	i.s.mutex.Lock()
+	defer i.s.mutex.Unlock()
+	i.s.counter++
+	path := dbus.ObjectPath(fmt.Sprintf("%s%d", secretSessionPrefix, i.s.counter))
+	i.s.sessions[path] = key
+	return output, path, nil
+}
+
+// CreateCollection returns the only collection, kpmenu doesn't create collections
+func (i secretServiceIface) CreateCollection(properties map[string]dbus.Variant, alias string) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return secretCollectionPath, secretNoPrompt, nil
+}
+
+// SearchItems returns the items with the attributes. The items of a locked database are unknown,
+// no item is returned until the collection is unlocked
+func (i secretServiceIface) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if !i.s.menu.Database.Loaded {
+		return []dbus.ObjectPath{}, []dbus.ObjectPath{}, nil
+	}
+	return i.s.search(attributes), []dbus.ObjectPath{}, nil
+}
+
+// Unlock returns a prompt asking the database password if the database is locked
+func (i secretServiceIface) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if i.s.menu.Database.Loaded {
+		return objects, secretNoPrompt, nil
+	}
+	return []dbus.ObjectPath{}, i.s.newPrompt(objects), nil
+}
+
+// Lock locks the database
+func (i secretServiceIface) Lock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	i.s.menu.lockDatabase()
+	return objects, secretNoPrompt, nil
+}
+
+// GetSecrets returns the secrets of the items
+func (i secretServiceIface) GetSecrets(items []dbus.ObjectPath, session dbus.ObjectPath) (map[dbus.ObjectPath]secretValue, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if !i.s.menu.Database.Loaded {
+		return nil, errSecretIsLocked
+	}
+	secrets := make(map[dbus.ObjectPath]secretValue)
+	for _, path := range items {
+		if e := i.s.item(path); e != nil {
+			secret, err := i.s.secret(e, session)
+			if err != nil {
+				return nil, err
+			}
+			secrets[path] = secret
+		}
+	}
+	return secrets, nil
+}
+
+// ReadAlias returns the collection for the default alias
+func (i secretServiceIface) ReadAlias(name string) (dbus.ObjectPath, *dbus.Error) {
+	if name == "default" {
+		return secretCollectionPath, nil
+	}
+	return secretNoPrompt, nil
+}
+
+// SetAlias is not supported, the only collection is the default one
+func (i secretServiceIface) SetAlias(name string, collection dbus.ObjectPath) *dbus.Error {
+	return errSecretNotSupported
+}
+
+// SearchItems returns the items of the collection with the attributes
+func (i secretCollectionIface) SearchItems(msg dbus.Message, attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	if !isSecretCollection(msg) {
+		return nil, errSecretNoSuchObject
+	}
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if !i.s.menu.Database.Loaded {
+		return nil, errSecretIsLocked
+	}
+	return i.s.search(attributes), nil
+}
+
+// CreateItem adds an entry into the group of the collection, with attributes as fields and the label as title.
+// If replace is true, the password of the entry with the same attributes is updated
+func (i secretCollectionIface) CreateItem(msg dbus.Message, properties map[string]dbus.Variant, secret secretValue, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if !isSecretCollection(msg) {
+		return secretNoPrompt, secretNoPrompt, errSecretNoSuchObject
+	}
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	m := i.s.menu
+	if !m.Database.Loaded {
+		return secretNoPrompt, secretNoPrompt, errSecretIsLocked
+	}
+
+	label, _ := properties[secretPropertyLabel].Value().(string)
+	attributes, _ := properties[secretPropertyAttribute].Value().(map[string]string)
+	if err := checkSecretAttributes(label, attributes); err != nil {
+		return secretNoPrompt, secretNoPrompt, err
+	}
+	password, err := i.s.secretPlaintext(secret)
+	if err != nil {
+		return secretNoPrompt, secretNoPrompt, err
+	}
+
+	if items := i.s.search(attributes); replace && len(attributes) > 0 && len(items) > 0 {
+		e := i.s.item(items[0])
+		if errDatabase := m.updateEntryField(e, "Password", password); errDatabase != nil {
+			return secretNoPrompt, secretNoPrompt, dbus.MakeFailedError(errors.New(errDatabase.String()))
+		}
+		return items[0], secretNoPrompt, nil
+	}
+
+	var group *GroupItem
+	groups := m.Database.Groups()
+	for j := range groups {
+		if groups[j].Path == strings.Trim(m.Configuration.General.SecretServiceGroup, "/") {
+			group = &groups[j]
+			break
+		}
+	}
+	if group == nil {
+		return secretNoPrompt, secretNoPrompt, dbus.MakeFailedError(errors.New("group of the collection not found"))
+	}
+
+	entry := gokeepasslib.NewEntry()
+	for k, v := range attributes {
+		SetEntryValue(&entry, k, v, false)
+	}
+	if label != "" {
+		SetEntryValue(&entry, "Title", label, false)
+	}
+	SetEntryValue(&entry, "Password", password, true)
+	if errDatabase := m.insertEntry(group, entry); errDatabase != nil {
+		return secretNoPrompt, secretNoPrompt, dbus.MakeFailedError(errors.New(errDatabase.String()))
+	}
+	path := secretItemPath(&Entry{UUID: entry.UUID})
+	i.s.conn.Emit(secretCollectionPath, secretIfaceCollection+".ItemCreated", path)
+	return path, secretNoPrompt, nil
+}
+
+// Delete is not supported, the collection is a group of the database
+func (i secretCollectionIface) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	return secretNoPrompt, errSecretNotSupported
+}
+
+// GetSecret returns the secret of the item
+func (i secretItemIface) GetSecret(msg dbus.Message, session dbus.ObjectPath) (secretValue, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if !i.s.menu.Database.Loaded {
+		return secretValue{}, errSecretIsLocked
+	}
+	e := i.s.item(messagePath(msg))
+	if e == nil {
+		return secretValue{}, errSecretNoSuchObject
+	}
+	return i.s.secret(e, session)
+}
+
+// SetSecret updates the password of the entry, keeping the previous one into the history
+func (i secretItemIface) SetSecret(msg dbus.Message, secret secretValue) *dbus.Error {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	if !i.s.menu.Database.Loaded {
+		return errSecretIsLocked
+	}
+	e := i.s.item(messagePath(msg))
+	if e == nil {
+		return errSecretNoSuchObject
+	}
+	password, err := i.s.secretPlaintext(secret)
+	if err != nil {
+		return err
+	}
+	if errDatabase := i.s.menu.updateEntryField(e, "Password", password); errDatabase != nil {
+		return dbus.MakeFailedError(errors.New(errDatabase.String()))
+	}
+	return nil
+}
+
+// Delete is not supported, entries are never deleted
+func (i secretItemIface) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	return secretNoPrompt, errSecretNotSupported
+}
+
+// Close closes the session
+func (i secretSessionIface) Close(msg dbus.Message) *dbus.Error {
+	i.s.mutex.Lock()
+	defer i.s.mutex.Unlock()
+	delete(i.s.sessions, messagePath(msg))
+	return nil
+}
+
+// Prompt asks the database password with the menu, Completed is emitted once done
+func (i secretPromptIface) Prompt(msg dbus.Message, windowID string) *dbus.Error {
+	path := messagePath(msg)
+	i.s.mutex.Lock()
+	_, ok := i.s.prompts[path]
+	i.s.mutex.Unlock()
+	if !ok {
+		return errSecretNoSuchObject
+	}
+
+	go func() {
+		m := i.s.menu
+		m.Mutex.Lock()
+		dismissed := false
+		if !m.Database.Loaded {
+			if err := m.OpenDatabase(); err != nil {
+				log.Print(err)
+				dismissed = true
+			} else if !m.Configuration.Flags.Daemon {
+				m.CacheStart = time.Now()
+			}
+		}
+		m.Mutex.Unlock()
+		i.s.completePrompt(path, dismissed)
+	}()
+	return nil
+}
+
+// Dismiss dismisses the prompt
+func (i secretPromptIface) Dismiss(msg dbus.Message) *dbus.Error {
+	i.s.completePrompt(messagePath(msg), true)
+	return nil
+}
+
+// Get returns a property of the object
+func (i secretPropertiesIface) Get(msg dbus.Message, iface string, name string) (dbus.Variant, *dbus.Error) {
+	properties, err := i.GetAll(msg, iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := properties[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+	}
+	return value, nil
+}
+
+// GetAll returns the properties of the object
+func (i secretPropertiesIface) GetAll(msg dbus.Message, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	i.s.menu.Mutex.Lock()
+	defer i.s.menu.Mutex.Unlock()
+	path := messagePath(msg)
+	locked := !i.s.menu.Database.Loaded
+
+	switch {
+	case iface == secretIfaceService && path == secretServicePath:
+		return map[string]dbus.Variant{
+			"Collections": dbus.MakeVariant([]dbus.ObjectPath{secretCollectionPath}),
+		}, nil
+	case iface == secretIfaceCollection && isSecretCollection(msg):
+		items := []dbus.ObjectPath{}
+		for _, e := range i.s.entries() {
+			items = append(items, secretItemPath(e))
+		}
+		label := strings.Trim(i.s.menu.Configuration.General.SecretServiceGroup, "/")
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(label),
+			"Locked":   dbus.MakeVariant(locked),
+			"Created":  dbus.MakeVariant(uint64(0)),
+			"Modified": dbus.MakeVariant(uint64(0)),
+		}, nil
+	case iface == secretIfaceItem:
+		e := i.s.item(path)
+		if e == nil {
+			break
+		}
+		var created, modified uint64
+		if t := e.FullEntry.Times.CreationTime; t != nil {
+			created = uint64(t.Time.Unix())
+		}
+		if t := e.FullEntry.Times.LastModificationTime; t != nil {
+			modified = uint64(t.Time.Unix())
+		}
+		return map[string]dbus.Variant{
+			"Locked":     dbus.MakeVariant(locked),
+			"Attributes": dbus.MakeVariant(secretAttributes(e)),
+			"Label":      dbus.MakeVariant(e.FullEntry.GetTitle()),
+			"Created":    dbus.MakeVariant(created),
+			"Modified":   dbus.MakeVariant(modified),
+		}, nil
+	}
+	return nil, errSecretNoSuchObject
+}
+
+// Set is not supported, properties are read-only
+func (i secretPropertiesIface) Set(msg dbus.Message, iface string, name string, value dbus.Variant) *dbus.Error {
+	return errSecretNotSupported
+}
+
+// secretItemPath returns the path of the item of the entry
+func secretItemPath(e *Entry) dbus.ObjectPath {
+	return secretCollectionPath + "/" + dbus.ObjectPath(e.HexUUID())
+}
+
+// secretAttributes returns the attributes of the entry: its non-protected custom fields,
+// Title, UserName, URL, Path and Uuid
+func secretAttributes(e *Entry) map[string]string {
+	attributes := map[string]string{
+		"Title":    e.FullEntry.GetTitle(),
+		"UserName": e.FullEntry.GetContent("UserName"),
+		"URL":      e.FullEntry.GetContent("URL"),
+		"Path":     e.FullPath(),
+		"Uuid":     e.HexUUID(),
+	}
+	for _, v := range e.FullEntry.Values {
+		if !isStandardField(v.Key) && !v.Value.Protected.Bool {
+			attributes[v.Key] = v.Value.Content
+		}
+	}
+	return attributes
+}
+
+// checkSecretAttributes checks that the attributes can be saved as fields of the entry and found again.
+// The password is the secret, the notes are not an attribute, Path and Uuid are given by the entry
+// and the title is the label if given
+func checkSecretAttributes(label string, attributes map[string]string) *dbus.Error {
+	for k, v := range attributes {
+		switch {
+		case k == "Password", k == "Notes", k == "Path", k == "Uuid":
+			return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf("the attribute %s can't be saved", k)})
+		case k == "Title" && label != "" && v != label:
+			return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"the Title attribute is different from the label"})
+		}
+	}
+	return nil
+}
+
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return path
+}
+
+func isSecretCollection(msg dbus.Message) bool {
+	path := messagePath(msg)
+	return path == secretCollectionPath || path == secretAliasPath
+}
+
+// secretExchangeKeys makes the public key of the server and the AES key of the session.
+// Public keys of the peer not in 2..p-2 are refused, they would make a known shared secret
+func secretExchangeKeys(peer []byte) ([]byte, []byte, error) {
+	peerKey := new(big.Int).SetBytes(peer)
+	if peerKey.Cmp(big.NewInt(1)) <= 0 || peerKey.Cmp(new(big.Int).Sub(secretDHPrime, big.NewInt(1))) >= 0 {
+		return nil, nil, errors.New("invalid public key")
+	}
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(secretDHPrime, big.NewInt(2)))
+	if err != nil {
+		return nil, nil, err
+	}
+	private.Add(private, big.NewInt(1))
+	public := new(big.Int).Exp(big.NewInt(2), private, secretDHPrime)
+	shared := new(big.Int).Exp(peerKey, private, secretDHPrime)
+
+	// The shared secret is padded to the size of the prime
+	ikm := make([]byte, (secretDHPrime.BitLen()+7)/8)
+	shared.FillBytes(ikm)
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, nil, nil), key); err != nil {
+		return nil, nil, err
+	}
+	return public.Bytes(), key, nil
+}
+
+func secretEncrypt(key []byte, iv []byte, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func secretDecrypt(key []byte, iv []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted secret")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding of the secret")
+	}
+	return plain[:len(plain)-padding], nil
+}
@@ -0,0 +1,301 @@
+package kpmenulib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math/big"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestSecretDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	iv := bytes.Repeat([]byte{2}, aes.BlockSize)
+	for _, value := range []string{"", "secret", "exactly 16 bytes"} {
+		plain, err := secretDecrypt(key, iv, secretEncrypt(key, iv, []byte(value)))
+		if err != nil || string(plain) != value {
+			t.Errorf("secretDecrypt(secretEncrypt(%q)) = %q, %v", value, plain, err)
+		}
+	}
+
+	// Encrypt blocks without adding the padding
+	encrypt := func(plain []byte) []byte {
+		block, _ := aes.NewCipher(key)
+		data := append([]byte{}, plain...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a block", []byte("short")},
+		{"zero padding", encrypt(append([]byte("secret0123456789"[:15]), 0))},
+		{"padding too long", encrypt(append([]byte("secret0123456789"[:15]), 17))},
+		{"wrong padding byte", encrypt(append([]byte("secret01234567"), 1, 2))},
+		{"wrong first padding byte", encrypt(append([]byte("secret012345"), 3, 4, 4, 4))},
+	}
+	for _, tt := range tests {
+		if plain, err := secretDecrypt(key, iv, tt.data); err == nil {
+			t.Errorf("%s: secretDecrypt() = %q, want an error", tt.name, plain)
+		}
+	}
+}
+
+func TestSecretExchangeKeys(t *testing.T) {
+	pMinus1 := new(big.Int).Sub(secretDHPrime, big.NewInt(1))
+	for _, peer := range []*big.Int{big.NewInt(0), big.NewInt(1), pMinus1, secretDHPrime, new(big.Int).Add(secretDHPrime, big.NewInt(2))} {
+		if _, _, err := secretExchangeKeys(peer.Bytes()); err == nil {
+			t.Errorf("secretExchangeKeys(%x) succeeded, want an error", peer)
+		}
+	}
+	for _, peer := range []*big.Int{big.NewInt(2), new(big.Int).Sub(secretDHPrime, big.NewInt(2))} {
+		if _, _, err := secretExchangeKeys(peer.Bytes()); err != nil {
+			t.Errorf("secretExchangeKeys(%x) failed: %v", peer, err)
+		}
+	}
+}
+
+// startTestBus starts a private dbus-daemon used as session bus by the test,
+// the test is skipped if dbus-daemon is not installed
+func startTestBus(t *testing.T) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("failed to start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the address of dbus-daemon: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+func newSecretServiceTestMenu() *Menu {
+	m := NewMenu()
+	m.Configuration.General.SecretServiceGroup = "Web"
+	m.Database = newTestDatabaseGroups(
+		newTestGroup("Web", newTestEntry("Title", "Forum", "UserName", "alice", "Password", "forumpw")),
+		newTestGroup("Private", newTestEntry("Title", "Bank", "UserName", "alice", "Password", "bankpw")),
+	)
+	return m
+}
+
+func TestSecretServiceBus(t *testing.T) {
+	startTestBus(t)
+	s, err := StartSecretService(newSecretServiceTestMenu())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	service := conn.Object(SecretServiceName, secretServicePath)
+
+	// Only the entries of the group are exposed
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.Call(secretIfaceService+".SearchItems", 0, map[string]string{"UserName": "alice"}).Store(&unlocked, &locked); err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocked) != 1 || len(locked) != 0 {
+		t.Fatalf("SearchItems() = %v, %v, want one unlocked item", unlocked, locked)
+	}
+	label, err := conn.Object(SecretServiceName, secretAliasPath).GetProperty(secretIfaceCollection + ".Label")
+	if err != nil || label.Value() != "Web" {
+		t.Errorf("Label = %v, %v, want Web", label, err)
+	}
+
+	// Plain session
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.Call(secretIfaceService+".OpenSession", 0, secretAlgorithmPlain, dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	var secrets map[dbus.ObjectPath]secretValue
+	if err := service.Call(secretIfaceService+".GetSecrets", 0, unlocked, session).Store(&secrets); err != nil {
+		t.Fatal(err)
+	}
+	if value := string(secrets[unlocked[0]].Value); value != "forumpw" {
+		t.Errorf("GetSecrets() with plain session = %q, want forumpw", value)
+	}
+
+	// Encrypted session
+	private, _ := rand.Int(rand.Reader, secretDHPrime)
+	public := new(big.Int).Exp(big.NewInt(2), private, secretDHPrime)
+	if err := service.Call(secretIfaceService+".OpenSession", 0, secretAlgorithmDH, dbus.MakeVariant(public.Bytes())).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := output.Value().([]byte)
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(peer), private, secretDHPrime)
+	ikm := make([]byte, (secretDHPrime.BitLen()+7)/8)
+	shared.FillBytes(ikm)
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, nil, nil), key); err != nil {
+		t.Fatal(err)
+	}
+	var secret secretValue
+	if err := conn.Object(SecretServiceName, unlocked[0]).Call(secretIfaceItem+".GetSecret", 0, session).Store(&secret); err != nil {
+		t.Fatal(err)
+	}
+	value, err := secretDecrypt(key, secret.Parameters, secret.Value)
+	if err != nil || string(value) != "forumpw" {
+		t.Errorf("GetSecret() with encrypted session = %q, %v, want forumpw", value, err)
+	}
+}
+
+func TestSecretServiceLocked(t *testing.T) {
+	startTestBus(t)
+	m := NewMenu()
+	m.Configuration.General.SecretServiceGroup = "Web"
+	s, err := StartSecretService(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	service := conn.Object(SecretServiceName, secretServicePath)
+
+	// The items of the locked database are unknown
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.Call(secretIfaceService+".SearchItems", 0, map[string]string{"UserName": "alice"}).Store(&unlocked, &locked); err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocked) != 0 || len(locked) != 0 {
+		t.Fatalf("SearchItems() = %v, %v, want no items", unlocked, locked)
+	}
+
+	// The collection is unlocked with a prompt
+	var prompt dbus.ObjectPath
+	if err := service.Call(secretIfaceService+".Unlock", 0, []dbus.ObjectPath{secretCollectionPath}).Store(&unlocked, &prompt); err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocked) != 0 || prompt == secretNoPrompt {
+		t.Errorf("Unlock() = %v, %v, want a prompt", unlocked, prompt)
+	}
+}
+
+func TestSecretServiceCreateItem(t *testing.T) {
+	startTestBus(t)
+	m := newSecretServiceTestMenu()
+	m.Configuration.Database.Database = newTestConfiguration(t).Database.Database
+	m.Database.AddCredentialsToDatabase(m.Configuration, "password")
+	writeTestDatabase(t, m.Configuration, m.Database)
+	s, err := StartSecretService(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := conn.Object(SecretServiceName, secretServicePath).Call(secretIfaceService+".OpenSession", 0, secretAlgorithmPlain, dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+
+	service := conn.Object(SecretServiceName, secretServicePath)
+	searchItems := func(attributes map[string]string) []dbus.ObjectPath {
+		var unlocked, locked []dbus.ObjectPath
+		if err := service.Call(secretIfaceService+".SearchItems", 0, attributes).Store(&unlocked, &locked); err != nil {
+			t.Fatal(err)
+		}
+		return unlocked
+	}
+	createItem := func(label string, attributes map[string]string) (dbus.ObjectPath, gokeepasslib.Entry, error) {
+		properties := map[string]dbus.Variant{
+			secretPropertyLabel:     dbus.MakeVariant(label),
+			secretPropertyAttribute: dbus.MakeVariant(attributes),
+		}
+		secret := secretValue{Session: session, Parameters: []byte{}, Value: []byte("itempw"), ContentType: "text/plain"}
+		var item, prompt dbus.ObjectPath
+		err := conn.Object(SecretServiceName, secretAliasPath).Call(secretIfaceCollection+".CreateItem", 0, properties, secret, false).Store(&item, &prompt)
+		if err != nil {
+			return item, gokeepasslib.Entry{}, err
+		}
+		// The database is changed by the bus
+		m.Mutex.Lock()
+		defer m.Mutex.Unlock()
+		e := s.item(item)
+		if e == nil {
+			t.Fatalf("created item %s not found", item)
+		}
+		entry := e.FullEntry
+		entry.Values = append([]gokeepasslib.ValueData{}, entry.Values...)
+		return item, entry, nil
+	}
+
+	// Attributes named as standard fields are saved into them
+	attributes := map[string]string{
+		"Title":    "Label",
+		"UserName": "alice",
+		"URL":      "https://example.com",
+		"app":      "kpmenu",
+	}
+	item, e, err := createItem("Label", attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Title": "Label", "UserName": "alice", "URL": "https://example.com", "app": "kpmenu", "Password": "itempw"}
+	for field, value := range want {
+		if got := e.GetContent(field); got != value {
+			t.Errorf("field %s = %q, want %q", field, got, value)
+		}
+	}
+	if items := searchItems(attributes); len(items) != 1 || items[0] != item {
+		t.Errorf("SearchItems() of the created item attributes = %v, want %s", items, item)
+	}
+	if _, e, err := createItem("", map[string]string{"Title": "Attribute title"}); err != nil || e.GetTitle() != "Attribute title" {
+		t.Errorf("CreateItem() without label = %v, want the title of the attribute", err)
+	}
+
+	// Attributes that can't be saved are refused
+	items := len(searchItems(map[string]string{}))
+	for _, attributes := range []map[string]string{
+		{"Password": "attributepw"},
+		{"Notes": "Attribute notes"},
+		{"Path": "Web/Other"},
+		{"Uuid": "0123456789abcdef0123456789abcdef"},
+		{"Title": "Attribute title"},
+	} {
+		if _, _, err := createItem("Label", attributes); err == nil {
+			t.Errorf("CreateItem() with the attributes %v succeeded, want an error", attributes)
+		}
+	}
+	if added := len(searchItems(map[string]string{})) - items; added != 0 {
+		t.Errorf("%d items added with refused attributes", added)
+	}
+}
@@ -0,0 +1,221 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSH key constants
+const (
+	SSHKeyField      = "SSHKey"            // Custom field with the name of the attachment containing the private key
+	KeeAgentSettings = "KeeAgent.settings" // Attachment with the settings of KeeAgent and KeePassXC
+)
+
+// SSHAgent is an ssh-agent serving the SSH keys of the database
+type SSHAgent struct {
+	keyring  agent.Agent
+	listener net.Listener
+	path     string
+}
+
+// keeAgentSettings is the part of KeeAgent.settings used to load keys
+type keeAgentSettings struct {
+	AllowUseOfSshKey  bool
+	AddAtDatabaseOpen bool
+	Location          struct {
+		SelectedType   string
+		AttachmentName string
+	}
+}
+
+// StartSSHAgent listens for SSH agent clients on the Unix socket
+func StartSSHAgent(path string) (*SSHAgent, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to make ssh agent socket folder: %v", err)
+	}
+	// Remove a stale socket, the server is not running
+	os.Remove(path)
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ssh agent: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set ssh agent socket permissions: %v", err)
+	}
+
+	a := &SSHAgent{
+		keyring:  agent.NewKeyring(),
+		listener: listener,
+		path:     path,
+	}
+	go a.serve()
+	log.Printf("ssh agent listening on %s", path)
+	return a, nil
+}
+
+func (a *SSHAgent) serve() {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			// Listener closed
+			return
+		}
+		// Only the same user can use the keys
+		if err := checkPeer(conn); err != nil {
+			log.Printf("refused ssh agent client: %v", err)
+			conn.Close()
+			continue
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := agent.ServeAgent(a.keyring, conn); err != nil && err != io.EOF {
+				log.Printf("ssh agent client failed: %v", err)
+			}
+		}(conn)
+	}
+}
+
+// Load replaces the keys of the agent with the keys of the database
+func (a *SSHAgent) Load(db *Database) {
+	a.keyring.RemoveAll()
+	count := 0
+	for i := range db.Entries {
+		e := &db.Entries[i]
+		data, err := sshKeyAttachment(db.Keepass, e.FullEntry)
+		if err != nil {
+			log.Printf("failed to get ssh key of entry %s: %v", e.FullPath(), err)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+
+		key, err := ssh.ParseRawPrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			// Encrypted keys use the password of the entry as passphrase, references resolved
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(db.EntryValue(e, "Password")))
+		}
+		if err != nil {
+			log.Printf("failed to parse ssh key of entry %s: %v", e.FullPath(), err)
+			continue
+		}
+		if err := a.keyring.Add(agent.AddedKey{PrivateKey: key, Comment: e.FullEntry.GetTitle()}); err != nil {
+			log.Printf("failed to add ssh key of entry %s: %v", e.FullPath(), err)
+			continue
+		}
+		count++
+	}
+	log.Printf("loaded %d ssh keys", count)
+}
+
+// Unload removes every key from the agent
+func (a *SSHAgent) Unload() {
+	a.keyring.RemoveAll()
+	log.Printf("unloaded ssh keys")
+}
+
+// Close stops the agent and removes its socket
+func (a *SSHAgent) Close() {
+	a.keyring.RemoveAll()
+	a.listener.Close()
+	os.Remove(a.path)
+}
+
+// sshKeyAttachment returns the private key attached to the entry, nil if the entry has no SSH key.
+// The attachment is named by the SSHKey field or by KeeAgent.settings
+func sshKeyAttachment(kp *gokeepasslib.Database, entry gokeepasslib.Entry) ([]byte, error) {
+	name := entry.GetContent(SSHKeyField)
+	if name == "" {
+		data, err := entryAttachment(kp, entry, KeeAgentSettings)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		settings, err := parseKeeAgentSettings(data)
+		if err != nil {
+			return nil, err
+		}
+		if !settings.AllowUseOfSshKey || !settings.AddAtDatabaseOpen || settings.Location.SelectedType != "attachment" {
+			return nil, nil
+		}
+		name = settings.Location.AttachmentName
+	}
+
+	data, err := entryAttachment(kp, entry, name)
+	if err == nil && data == nil {
+		err = fmt.Errorf("attachment %s not found", name)
+	}
+	return data, err
+}
+
+// entryAttachment returns the content of the attachment, nil if not found
+func entryAttachment(kp *gokeepasslib.Database, entry gokeepasslib.Entry, name string) ([]byte, error) {
+	for _, ref := range entry.Binaries {
+		if ref.Name != name {
+			continue
+		}
+		bin := ref.Find(kp)
+		if bin == nil {
+			return nil, fmt.Errorf("attachment %s not found into the database", name)
+		}
+		return bin.GetContentBytes()
+	}
+	return nil, nil
+}
+
+// parseKeeAgentSettings parses KeeAgent.settings, usually written as UTF-16
+func parseKeeAgentSettings(data []byte) (keeAgentSettings, error) {
+	var settings keeAgentSettings
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	}
+	if order != nil {
+		units := make([]uint16, (len(data)-2)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2+i*2:])
+		}
+		data = []byte(string(utf16.Decode(units)))
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Already decoded, ignore the encoding of the declaration
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := dec.Decode(&settings); err != nil {
+		return settings, fmt.Errorf("failed to parse %s: %v", KeeAgentSettings, err)
+	}
+	return settings, nil
+}
+
+// getSSHAgentSocketPath returns the path of the SSH agent socket,
+// into $XDG_RUNTIME_DIR if set otherwise into the cache folder
+func getSSHAgentSocketPath(m *Menu) string {
+	if m.Configuration.General.SSHAgentSocket != "" {
+		return m.Configuration.General.SSHAgentSocket
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "kpmenu", "ssh-agent.sock")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache/kpmenu/ssh-agent.sock")
+}
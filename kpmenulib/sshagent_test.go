@@ -0,0 +1,87 @@
+package kpmenulib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSSHAgentLoad(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestDatabase(
+		newTestEntry("Title", "Target", "Password", "passphrase"),
+		newTestEntry("Title", "Key", SSHKeyField, "id_ecdsa"),
+		newTestEntry("Title", "Wrong passphrase", SSHKeyField, "id_ecdsa", "Password", "wrong"),
+	)
+	binaries := &db.Keepass.Content.Meta.Binaries
+	if db.Keepass.Header.IsKdbx4() {
+		binaries = &db.Keepass.Content.InnerHeader.Binaries
+	}
+	reference := binaries.Add(pem.EncodeToMemory(block)).CreateReference("id_ecdsa")
+	for _, title := range []string{"Key", "Wrong passphrase"} {
+		e := testEntry(t, db, title)
+		e.FullEntry.Binaries = append(e.FullEntry.Binaries, reference)
+	}
+	// The passphrase is referenced from another entry
+	e := testEntry(t, db, "Key")
+	SetEntryValue(&e.FullEntry, "Password", "{REF:P@I:"+testEntry(t, db, "Target").HexUUID()+"}", true)
+
+	a := &SSHAgent{keyring: agent.NewKeyring()}
+	a.Load(db)
+	keys, err := a.keyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "Key" {
+		t.Fatalf("loaded keys %v, want the key of the entry Key", keys)
+	}
+
+	a.Unload()
+	if keys, _ := a.keyring.List(); len(keys) != 0 {
+		t.Errorf("%d keys left after Unload()", len(keys))
+	}
+}
+
+func TestSSHAgentExit(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMenu()
+	m.Configuration.Flags.Daemon = true
+	m.Database = newTestDatabase(newTestEntry("Title", "Key"))
+	m.SSHAgent = &SSHAgent{keyring: agent.NewKeyring()}
+	if err := m.SSHAgent.keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "Key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Select Exit from the menu
+	m.Prompter = &fakePrompter{answers: []string{menuSelections[MenuExit]}}
+	if err := m.OpenMenu(); err == nil || !err.Fatal {
+		t.Fatalf("OpenMenu() = %v, want a fatal error", err)
+	}
+	if m.Database.Loaded {
+		t.Error("the database is still loaded after Exit")
+	}
+	if keys, _ := m.SSHAgent.keyring.List(); len(keys) != 0 {
+		t.Errorf("%d keys left after Exit", len(keys))
+	}
+}
@@ -0,0 +1,175 @@
+package kpmenulib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ttyPrompter asks everything in the terminal, without any external executable
+type ttyPrompter struct{}
+
+func (p *ttyPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	return readTerminalPassword(options.Label)
+}
+
+// Choose shows a numbered list of items, the user can write the number of the item
+// or a text to filter the list. A text that doesn't match any item is returned as is.
+func (p *ttyPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	i, result, errorPrompt := p.choose(options, items)
+	if i >= 0 {
+		result = items[i]
+	}
+	return result, errorPrompt
+}
+
+func (p *ttyPrompter) ChooseIndex(options PromptOptions, items []string) (int, ErrorPrompt) {
+	i, _, errorPrompt := p.choose(options, items)
+	return i, errorPrompt
+}
+
+// choose returns the index of the chosen item, or -1 and the written text
+func (p *ttyPrompter) choose(options PromptOptions, items []string) (index int, text string, errorPrompt ErrorPrompt) {
+	index = -1
+	tty, err := openTerminal()
+	if err != nil {
+		errorPrompt.Cancelled = true
+		errorPrompt.Error = err
+		return
+	}
+	defer tty.Close()
+	reader := bufio.NewReader(tty)
+
+	// Indexes of the shown items
+	filtered := make([]int, len(items))
+	for i := range items {
+		filtered[i] = i
+	}
+	for {
+		// Print the list
+		for n, i := range filtered {
+			fmt.Fprintf(tty, "%3d) %s\n", n+1, items[i])
+		}
+		if len(filtered) > 0 {
+			fmt.Fprintf(tty, "%s (number or filter): ", options.Label)
+		} else {
+			fmt.Fprintf(tty, "%s: ", options.Label)
+		}
+
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if err != nil || line == "" {
+			// EOF or empty line cancel the prompt
+			errorPrompt.Cancelled = true
+			return
+		}
+		if len(filtered) == 0 {
+			return -1, line, errorPrompt
+		}
+
+		// Select by number
+		if n, err := strconv.Atoi(line); err == nil && n > 0 && n <= len(filtered) {
+			return filtered[n-1], "", errorPrompt
+		}
+
+		// Filter the list
+		var matches []int
+		for _, i := range filtered {
+			if strings.Contains(strings.ToLower(items[i]), strings.ToLower(line)) {
+				matches = append(matches, i)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return -1, line, errorPrompt
+		case 1:
+			return matches[0], "", errorPrompt
+		}
+		filtered = matches
+	}
+}
+
+// fzfPrompter uses fzf for lists, the password is read from the terminal
+type fzfPrompter struct{}
+
+func (p *fzfPrompter) Password(options PromptOptions) (string, ErrorPrompt) {
+	return readTerminalPassword(options.Label)
+}
+
+func (p *fzfPrompter) Choose(options PromptOptions, items []string) (string, ErrorPrompt) {
+	command := []string{
+		"fzf",
+		"-i",
+		"--prompt", options.Label + ": ",
+		"--bind", "enter:accept-or-print-query", // Return the query if nothing matches, like dmenu
+	}
+	return executePrompt(append(command, options.Args...), itemsReader(items))
+}
+
+// ChooseIndex prefixes every item with its index, hidden by fzf and returned with the selection
+func (p *fzfPrompter) ChooseIndex(options PromptOptions, items []string) (int, ErrorPrompt) {
+	indexed := make([]string, len(items))
+	for i, item := range items {
+		indexed[i] = fmt.Sprintf("%d\t%s", i, item)
+	}
+	command := []string{
+		"fzf",
+		"-i",
+		"--prompt", options.Label + ": ",
+		"--delimiter", "\t",
+		"--with-nth", "2..",
+	}
+	result, errorPrompt := executePrompt(append(command, options.Args...), itemsReader(indexed))
+	return parseIndex(strings.SplitN(result, "\t", 2)[0], len(items)), errorPrompt
+}
+
+// openTerminal opens the controlling terminal of the process
+func openTerminal() (*os.File, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal: %v", err)
+	}
+	return tty, nil
+}
+
+// readTerminalPassword reads a password from the terminal with echo disabled
+func readTerminalPassword(label string) (result string, errorPrompt ErrorPrompt) {
+	tty, err := openTerminal()
+	if err != nil {
+		errorPrompt.Cancelled = true
+		errorPrompt.Error = err
+		return
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s: ", label)
+	password, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil || len(password) == 0 {
+		errorPrompt.Cancelled = true
+		return
+	}
+	return string(password), errorPrompt
+}
+
+// writeOSC52 sets the clipboard of the terminal emulator with the OSC 52 escape sequence
+// an empty text cleans the clipboard
+func writeOSC52(text string) error {
+	tty, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	data := "!"
+	if text != "" {
+		data = base64.StdEncoding.EncodeToString([]byte(text))
+	}
+	_, err = fmt.Fprintf(tty, "\x1b]52;c;%s\x07", data)
+	return err
+}
@@ -0,0 +1,116 @@
+package kpmenulib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// GetActiveWindowTitle gets the title of the focused window
+func GetActiveWindowTitle(menu *Menu) (string, error) {
+	var out bytes.Buffer
+
+	command, err := shlex.Split(menu.Configuration.Executable.ActiveWindowTitle)
+	if err != nil {
+		return "", errors.New("failed to parse active window title executable")
+	}
+	if len(command) == 0 {
+		return "", errors.New("the active window title executable is empty")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+
+	// Set stdout
+	cmd.Stdout = &out
+
+	// Run exec
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get active window title: %v", err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// MatchWindow checks if the entry matches the window title,
+// by its autotype window associations or by the host of its URL.
+// The placeholders of both are resolved before matching
+func MatchWindow(db *Database, e *Entry, title string) bool {
+	if title == "" {
+		return false
+	}
+	for _, association := range e.FullEntry.AutoType.Associations {
+		if matchWindowPattern(db.ResolvePlaceholders(association.Window, e), title) {
+			return true
+		}
+	}
+	return matchWindowHost(urlHost(db.EntryValue(e, "URL")), title)
+}
+
+// matchWindowHost checks if the title contains the host as whole labels, the host or a subdomain of it.
+// Hosts without a dot, like localhost, match only a title equal to them
+func matchWindowHost(host string, title string) bool {
+	if host == "" || strings.IndexFunc(host, func(r rune) bool { return r > 127 || !isHostByte(byte(r)) && r != '.' }) >= 0 {
+		// Empty or not a host name, like an unresolved placeholder
+		return false
+	}
+	title = strings.ToLower(title)
+	if !strings.Contains(host, ".") {
+		return strings.TrimSpace(title) == host
+	}
+	for i := strings.Index(title, host); i >= 0; {
+		rest := title[i+len(host):]
+		continues := rest != "" && (isHostByte(rest[0]) || rest[0] == '.' && len(rest) > 1 && isHostByte(rest[1]))
+		if (i == 0 || !isHostByte(title[i-1])) && !continues {
+			return true
+		}
+		next := strings.Index(title[i+1:], host)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return false
+}
+
+// isHostByte checks if the byte can be part of a label of a host name
+func isHostByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-'
+}
+
+// matchWindowPattern matches a KeePass window pattern with the title.
+// Patterns enclosed by // are regular expressions, otherwise * and ? are wildcards,
+// both are case insensitive
+func matchWindowPattern(pattern string, title string) bool {
+	if pattern == "" {
+		return false
+	}
+
+	var expression string
+	if len(pattern) > 4 && strings.HasPrefix(pattern, "//") && strings.HasSuffix(pattern, "//") {
+		expression = pattern[2 : len(pattern)-2]
+	} else {
+		var b strings.Builder
+		b.WriteString("^")
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		b.WriteString("$")
+		expression = b.String()
+	}
+
+	reg, err := regexp.Compile("(?i)" + expression)
+	if err != nil {
+		return false
+	}
+	return reg.MatchString(title)
+}
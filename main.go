@@ -11,9 +11,23 @@ func main() {
 	menu := kpmenulib.Initialize()
 
 	if menu != nil {
-		// Start client
-		err := kpmenulib.StartClient()
-		if err != nil {
+		if menu.Configuration.Flags.Command == kpmenulib.CommandBrowserHost {
+			// Started by the browser, messages are executed by the running kpmenu
+			os.Exit(kpmenulib.StartBrowserHost(menu))
+		}
+
+		// Start client, terminal menus are executed in this process
+		exitCode, err := kpmenulib.ExitNotRunning, kpmenulib.ErrNotRunning
+		if menu.Configuration.Flags.Request() != kpmenulib.RequestShow || !menu.Configuration.TerminalBound() {
+			exitCode, err = kpmenulib.StartClient(menu)
+		}
+		if err == nil {
+			os.Exit(exitCode)
+		} else if menu.Configuration.Flags.Request() != kpmenulib.RequestShow {
+			// Nothing to do without a server
+			log.Print(err)
+			os.Exit(exitCode)
+		} else {
 			// Failed to comunicate with server - start server
 			err = kpmenulib.StartServer(menu)
 